@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/apikeys"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+	"github.com/gorilla/mux"
+)
+
+// apiKeys holds the partner API keys, nil when API keys are disabled.
+var apiKeys *apikeys.Store
+
+// apiKeysRequired turns away requests without a key instead of treating them as anonymous.
+var apiKeysRequired bool
+
+type apiKeyContextKey struct{}
+
+// requestKey returns the API key the request was made with, or nil.
+func requestKey(r *http.Request) *apikeys.Key {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*apikeys.Key)
+	return key
+}
+
+// apiKeyAuth authenticates requests that carry an API key in X-API-Key or an
+// Authorization: Bearer header and counts them against the key's daily request quota.
+// Files under /videos/ and /archive/ are exempt, as their links are handed out by the API
+// itself and are public, and so are the health checks the orchestrator polls. Scrapes of /metrics are
+// authenticated but not counted.
+func apiKeyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeys == nil || strings.HasPrefix(r.URL.Path, "/videos/") || strings.HasPrefix(r.URL.Path, "/archive/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-API-Key")
+		if secret == "" {
+			secret = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if secret == "" {
+			if apiKeysRequired {
+				jsonError(w, errAPIKeyRequired, "An API key is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, ok := apiKeys.Lookup(secret)
+		if !ok {
+			slog.WarnContext(r.Context(), "Rejected invalid API key", "client", clientID(r))
+			jsonError(w, errInvalidAPIKey, "Invalid API key")
+			return
+		}
+
+		if r.URL.Path != "/metrics" {
+			if err := apiKeys.Allow(key); errors.Is(err, apikeys.ErrRequestQuota) {
+				jsonError(w, errQuotaExceeded, "Daily request quota exceeded")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// checkKeyQuota responds with an error and returns false if the request's API key may not
+// download in format (defaultFormat when empty) or has used up its daily download allowance.
+func checkKeyQuota(w http.ResponseWriter, r *http.Request, format, defaultFormat string) bool {
+	key := requestKey(r)
+	if key == nil {
+		return true
+	}
+	if format == "" {
+		format = defaultFormat
+	}
+	if !key.AllowsFormat(format) {
+		jsonError(w, errFormatNotAllowed, fmt.Sprintf("This API key may not download %s files", format))
+		return false
+	}
+	if err := apiKeys.CheckBytes(key); err != nil {
+		jsonError(w, errQuotaExceeded, "Daily download quota exceeded")
+		return false
+	}
+	return true
+}
+
+// recordDownload counts the size of a finished download against the request's API key.
+func recordDownload(r *http.Request, result *downloader.Result) {
+	recordUsage(requestKey(r), result.FilePath)
+}
+
+// recordUsage counts the size of the file at path against key, if set.
+func recordUsage(key *apikeys.Key, path string) {
+	if key == nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	apiKeys.AddBytes(key, info.Size())
+}
+
+// usageFlushInterval is how often the API key usage counters are written to disk.
+const usageFlushInterval = 30 * time.Second
+
+// startUsageFlush writes the API key usage counters to disk in the background.
+// shutdown flushes them one last time.
+func startUsageFlush() {
+	go func() {
+		for {
+			time.Sleep(usageFlushInterval)
+			if err := apiKeys.Flush(); err != nil {
+				slog.Error("Error saving API key usage", "error", err)
+			}
+		}
+	}()
+}
+
+// requireAdmin only lets requests made with an admin API key through to next.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiKeys == nil {
+			notFound(w, r)
+			return
+		}
+		if key := requestKey(r); key == nil || !key.Admin {
+			jsonError(w, errAdminRequired, "An admin API key is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// listKeys lists every API key with today's usage, without their secrets.
+func listKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": apiKeys.List()})
+}
+
+// rotateKey replaces the secret of an API key and returns the new one. This is the only
+// time the new secret is shown.
+func rotateKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	secret, err := apiKeys.Rotate(id)
+	if errors.Is(err, apikeys.ErrKeyNotFound) {
+		jsonError(w, errKeyNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rotating API key", "error", err)
+		jsonError(w, errInternal, "Failed to rotate API key")
+		return
+	}
+	slog.InfoContext(r.Context(), "Rotated API key", "id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "key": secret})
+}
+
+// showConfig returns the configuration the server is running with, without its secrets.
+func showConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg.Redacted())
+}
@@ -0,0 +1,337 @@
+// Package apikeys authenticates partner integrations by API key and enforces their
+// daily quotas. Keys are read from a JSON config file. Usage counters are kept in
+// memory and flushed to a second file, so they survive restarts.
+//
+//	{
+//	  "keys": [
+//	    {"id": "acme", "name": "Acme Corp", "key": "...", "requestsPerDay": 1000,
+//	     "bytesPerDay": 10737418240, "formats": ["mp4"]},
+//	    {"id": "ops", "name": "Operators", "key": "...", "admin": true}
+//	  ]
+//	}
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Errors returned when a key has used up its quota for the day.
+var (
+	ErrRequestQuota = errors.New("daily request quota exceeded")
+	ErrByteQuota    = errors.New("daily download quota exceeded")
+	ErrKeyNotFound  = errors.New("API key not found")
+)
+
+// Key is an API key and the quotas that apply to it. Zero quotas mean no limit.
+type Key struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Secret         string   `json:"key"`
+	RequestsPerDay int      `json:"requestsPerDay,omitempty"`
+	BytesPerDay    int64    `json:"bytesPerDay,omitempty"`
+	Formats        []string `json:"formats,omitempty"` // Output formats the key may request, all when empty
+	Admin          bool     `json:"admin,omitempty"`   // May list and rotate keys
+}
+
+// AllowsFormat reports whether the key may request downloads in format.
+func (k *Key) AllowsFormat(format string) bool {
+	if len(k.Formats) == 0 {
+		return true
+	}
+	for _, allowed := range k.Formats {
+		if allowed == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage counts what a key has used on a given day (UTC).
+type Usage struct {
+	Day      string `json:"day"`
+	Requests int    `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// KeyInfo describes a key without revealing its secret.
+type KeyInfo struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	KeyPrefix      string   `json:"keyPrefix"`
+	RequestsPerDay int      `json:"requestsPerDay"`
+	BytesPerDay    int64    `json:"bytesPerDay"`
+	Formats        []string `json:"formats"`
+	Admin          bool     `json:"admin"`
+	Usage          Usage    `json:"usage"`
+}
+
+type config struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Store holds the configured keys and their usage. It is safe for concurrent use.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	usagePath string
+	keys      []*Key
+	bySecret  map[[sha256.Size]byte]*Key
+	usage     map[string]*Usage
+	dirty     bool       // Usage changed since the last flush
+	flushMu   sync.Mutex // Keeps flushes in order
+}
+
+// Load reads the keys from path and the usage counters from usagePath, which
+// doesn't have to exist yet.
+func Load(path, usagePath string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %v", err)
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %v", err)
+	}
+
+	s := &Store{
+		path:      path,
+		usagePath: usagePath,
+		keys:      cfg.Keys,
+		bySecret:  make(map[[sha256.Size]byte]*Key),
+		usage:     make(map[string]*Usage),
+	}
+	ids := make(map[string]bool)
+	for _, key := range s.keys {
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("API key %q needs both an id and a key", key.Name)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate API key id: %s", key.ID)
+		}
+		ids[key.ID] = true
+		s.bySecret[sha256.Sum256([]byte(key.Secret))] = key
+	}
+
+	data, err = os.ReadFile(usagePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read API key usage: %v", err)
+	default:
+		if err := json.Unmarshal(data, &s.usage); err != nil {
+			return nil, fmt.Errorf("failed to parse API key usage: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Lookup returns the key with the given secret.
+func (s *Store) Lookup(secret string) (*Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Compare hashes so the lookup doesn't depend on how much of the secret matches
+	key, ok := s.bySecret[sha256.Sum256([]byte(secret))]
+	return key, ok
+}
+
+// Get returns the key with the given ID.
+func (s *Store) Get(id string) (*Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Allow counts a request against the key, failing with ErrRequestQuota once the
+// daily limit has been reached.
+func (s *Store) Allow(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.today(key.ID)
+	if key.RequestsPerDay > 0 && usage.Requests >= key.RequestsPerDay {
+		return ErrRequestQuota
+	}
+	usage.Requests++
+	s.dirty = true
+	return nil
+}
+
+// CheckBytes fails with ErrByteQuota if the key has already downloaded its daily allowance.
+func (s *Store) CheckBytes(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key.BytesPerDay > 0 && s.today(key.ID).Bytes >= key.BytesPerDay {
+		return ErrByteQuota
+	}
+	return nil
+}
+
+// AddBytes records the size of a download made with the key.
+func (s *Store) AddBytes(key *Key, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.today(key.ID).Bytes += n
+	s.dirty = true
+}
+
+// Flush writes the usage counters to disk if they changed since the last flush.
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err == nil {
+		err = writeFile(s.usagePath, data, 0644)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return fmt.Errorf("failed to save API key usage: %v", err)
+	}
+	return nil
+}
+
+// List describes every key along with today's usage.
+func (s *Store) List() []KeyInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := []KeyInfo{}
+	for _, key := range s.keys {
+		infos = append(infos, KeyInfo{
+			ID:             key.ID,
+			Name:           key.Name,
+			KeyPrefix:      prefix(key.Secret),
+			RequestsPerDay: key.RequestsPerDay,
+			BytesPerDay:    key.BytesPerDay,
+			Formats:        key.Formats,
+			Admin:          key.Admin,
+			Usage:          *s.today(key.ID),
+		})
+	}
+	return infos
+}
+
+// Rotate replaces the secret of a key with a new random one, saves the config file
+// and returns the new secret. The old secret stops working immediately.
+func (s *Store) Rotate(id string) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.ID != id {
+			continue
+		}
+
+		old := key.Secret
+		key.Secret = secret
+		if err := s.saveConfig(); err != nil {
+			key.Secret = old
+			return "", err
+		}
+
+		delete(s.bySecret, sha256.Sum256([]byte(old)))
+		s.bySecret[sha256.Sum256([]byte(secret))] = key
+		return secret, nil
+	}
+	return "", ErrKeyNotFound
+}
+
+// today returns the key's usage for the current day, starting a new day if needed.
+// The caller must hold the lock.
+func (s *Store) today(id string) *Usage {
+	day := time.Now().UTC().Format("2006-01-02")
+	usage, ok := s.usage[id]
+	if !ok || usage.Day != day {
+		usage = &Usage{Day: day}
+		s.usage[id] = usage
+	}
+	return usage
+}
+
+// saveConfig writes the keys back to the config file. The caller must hold the lock.
+func (s *Store) saveConfig() error {
+	if err := writeJSON(s.path, config{Keys: s.keys}, 0600); err != nil {
+		return fmt.Errorf("failed to save API keys: %v", err)
+	}
+	return nil
+}
+
+// writeJSON replaces the file at path atomically, so a crash never leaves it half written.
+func writeJSON(path string, v interface{}, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data, perm)
+}
+
+// writeFile is writeJSON for data that has already been encoded.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	return "bsk_" + hex.EncodeToString(b), nil
+}
+
+// prefix returns enough of a secret to tell keys apart in listings.
+func prefix(secret string) string {
+	if len(secret) <= 8 {
+		return ""
+	}
+	return secret[:8]
+}
@@ -0,0 +1,52 @@
+package apikeys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageIsFlushed(t *testing.T) {
+	dir := t.TempDir()
+	path, usagePath := filepath.Join(dir, "keys.json"), filepath.Join(dir, "usage.json")
+	if err := os.WriteFile(path, []byte(`{"keys":[{"id":"acme","key":"secret","requestsPerDay":2}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(path, usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := s.Get("acme")
+	for i := 0; i < 2; i++ {
+		if err := s.Allow(key); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if err := s.Allow(key); !errors.Is(err, ErrRequestQuota) {
+		t.Fatalf("third request error = %v, want ErrRequestQuota", err)
+	}
+	s.AddBytes(key, 1024)
+
+	// Counting doesn't touch the disk, only flushing does
+	if _, err := os.Stat(usagePath); !os.IsNotExist(err) {
+		t.Fatalf("usage written before the flush: %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path, usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := s.List()[0].Usage
+	if usage.Requests != 2 || usage.Bytes != 1024 {
+		t.Errorf("usage after reload = %+v, want 2 requests and 1024 bytes", usage)
+	}
+	key, _ = s.Get("acme")
+	if err := s.Allow(key); !errors.Is(err, ErrRequestQuota) {
+		t.Errorf("request after reload error = %v, want ErrRequestQuota", err)
+	}
+}
@@ -0,0 +1,189 @@
+package bsky
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+)
+
+// Largest external media file we are willing to fetch.
+const maxExternalMediaSize = 50 << 20
+
+// ErrBlobTooLarge is returned by FetchBlob for blobs larger than Client.MaxBlobSize.
+var ErrBlobTooLarge = errors.New("blob is larger than allowed")
+
+// FetchBlob downloads a blob from the PDS hosting the DID's repository and verifies it against its CID.
+func (c *Client) FetchBlob(ctx context.Context, did, cid, outputPath string) error {
+	verifier, err := newCIDVerifier(cid)
+	if err != nil {
+		return err
+	}
+
+	pds, err := c.Identity.PDSEndpoint(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	blobURL := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", pds, url.QueryEscape(did), url.QueryEscape(cid))
+	slog.DebugContext(ctx, "Fetching blob", "url", blobURL)
+
+	resp, err := c.get(ctx, blobURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch blob: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("blob returned status code: %d", resp.StatusCode)
+	}
+	if c.MaxBlobSize > 0 && resp.ContentLength > c.MaxBlobSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrBlobTooLarge, resp.ContentLength, c.MaxBlobSize)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %v", err)
+	}
+
+	// Hash the blob while it is written to disk, reading one byte past the limit
+	// to detect oversized bodies without a Content-Length
+	var body io.Reader = resp.Body
+	if c.MaxBlobSize > 0 {
+		body = io.LimitReader(resp.Body, c.MaxBlobSize+1)
+	}
+	written, err := io.Copy(io.MultiWriter(out, verifier), body)
+	metrics.CDNBytes.WithLabelValues("blob").Add(float64(written))
+	out.Close()
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to save blob: %v", err)
+	}
+	if c.MaxBlobSize > 0 && written > c.MaxBlobSize {
+		os.Remove(outputPath)
+		return fmt.Errorf("%w: more than %d bytes", ErrBlobTooLarge, c.MaxBlobSize)
+	}
+
+	if err := verifier.Verify(); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	slog.InfoContext(ctx, "Blob verified against CID", "cid", cid)
+
+	return nil
+}
+
+// FetchImage saves an image from the CDN next to basePath and returns the file's path.
+// The extension follows the format the CDN served.
+func (c *Client) FetchImage(ctx context.Context, imageURL, basePath string) (string, error) {
+	slog.DebugContext(ctx, "Downloading image", "url", imageURL)
+
+	resp, err := c.get(ctx, imageURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("image returned status code: %d", resp.StatusCode)
+	}
+
+	// The CDN serves whatever format the URL suffix asks for
+	ext := ".jpg"
+	switch resp.Header.Get("Content-Type") {
+	case "image/png":
+		ext = ".png"
+	case "image/webp":
+		ext = ".webp"
+	}
+
+	outputPath := basePath + ext
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image file: %v", err)
+	}
+	written, err := io.Copy(out, resp.Body)
+	metrics.CDNBytes.WithLabelValues("image").Add(float64(written))
+	out.Close()
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to save image: %v", err)
+	}
+	return outputPath, nil
+}
+
+// FetchExternalMedia downloads media from an allowed host next to basePath and returns the file's path.
+func (c *Client) FetchExternalMedia(ctx context.Context, mediaURL, basePath string) (string, error) {
+	u, err := url.Parse(mediaURL)
+	if err != nil || !c.isAllowedExternalURL(u) {
+		return "", fmt.Errorf("host not allowed: %s", mediaURL)
+	}
+
+	// Every redirect has to stay on an allowed host as well
+	client := *c.HTTPClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		if !c.isAllowedExternalURL(req.URL) {
+			return fmt.Errorf("redirect to disallowed host: %s", req.URL.Hostname())
+		}
+		return nil
+	}
+
+	slog.DebugContext(ctx, "Fetching external media", "url", mediaURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("external media returned status code: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxExternalMediaSize {
+		return "", fmt.Errorf("external media is too large: %d bytes", resp.ContentLength)
+	}
+
+	ext := path.Ext(u.Path)
+	if ext == "" {
+		ext = ".gif"
+	}
+	outputPath := basePath + ext
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create media file: %v", err)
+	}
+
+	// Read one byte past the limit to detect oversized bodies without a Content-Length
+	written, err := io.Copy(out, io.LimitReader(resp.Body, maxExternalMediaSize+1))
+	metrics.CDNBytes.WithLabelValues("external").Add(float64(written))
+	out.Close()
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to save external media: %v", err)
+	}
+	if written > maxExternalMediaSize {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("external media is larger than %d bytes", maxExternalMediaSize)
+	}
+
+	return outputPath, nil
+}
@@ -0,0 +1,71 @@
+package bsky
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache holds values for a limited time. Expired entries are swept at most once a
+// minute, and when the cache is full the entry closest to expiring makes room for a
+// new one. The zero value is ready to use and safe for concurrent use.
+type ttlCache[V any] struct {
+	mu        sync.Mutex
+	entries   map[string]ttlEntry[V]
+	lastSweep time.Time
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// get returns the value cached for key, if it hasn't expired.
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// put caches value for ttl, keeping at most max entries when max is positive.
+func (c *ttlCache[V]) put(key string, value V, ttl time.Duration, max int) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]ttlEntry[V])
+	}
+	if now.Sub(c.lastSweep) >= time.Minute {
+		c.lastSweep = now
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	if _, ok := c.entries[key]; !ok && max > 0 && len(c.entries) >= max {
+		var oldest string
+		for k, entry := range c.entries {
+			if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(ttl)}
+}
+
+// len returns the number of entries, including expired ones not swept yet.
+func (c *ttlCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
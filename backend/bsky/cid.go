@@ -0,0 +1,91 @@
+package bsky
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Multicodec and multihash codes used by blob CIDs.
+const (
+	codecRaw        = 0x55
+	hashSHA256      = 0x12
+	sha256Length    = 32
+	multibaseBase32 = 'b' // lowercase, no padding
+)
+
+// ErrBlobVerification marks a blob that could not be proven to match its CID.
+var ErrBlobVerification = errors.New("blob verification failed")
+
+var cidEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// decodeCID parses a CIDv1 with the raw codec and a sha2-256 multihash and returns the digest.
+func decodeCID(cid string) ([]byte, error) {
+	if len(cid) < 2 || cid[0] != multibaseBase32 {
+		return nil, fmt.Errorf("unsupported CID multibase: %s", cid)
+	}
+
+	data, err := cidEncoding.DecodeString(strings.ToUpper(cid[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID encoding: %v", err)
+	}
+
+	// version, codec, hash function and digest length are all varints
+	var fields [4]uint64
+	for i := range fields {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("truncated CID: %s", cid)
+		}
+		fields[i] = value
+		data = data[n:]
+	}
+
+	version, codec, hashCode, length := fields[0], fields[1], fields[2], fields[3]
+	switch {
+	case version != 1:
+		return nil, fmt.Errorf("unsupported CID version: %d", version)
+	case codec != codecRaw:
+		return nil, fmt.Errorf("unsupported CID codec: 0x%x", codec)
+	case hashCode != hashSHA256:
+		return nil, fmt.Errorf("unsupported CID hash function: 0x%x", hashCode)
+	case length != sha256Length || len(data) != sha256Length:
+		return nil, fmt.Errorf("invalid CID digest length: %d", len(data))
+	}
+
+	return data, nil
+}
+
+// cidVerifier hashes a blob as it is written and checks the result against its CID.
+type cidVerifier struct {
+	cid    string
+	digest []byte
+	hash   hash.Hash
+}
+
+func newCIDVerifier(cid string) (*cidVerifier, error) {
+	digest, err := decodeCID(cid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBlobVerification, err)
+	}
+	return &cidVerifier{cid: cid, digest: digest, hash: sha256.New()}, nil
+}
+
+func (v *cidVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+// Verify reports an error if the bytes written so far don't hash to the CID.
+func (v *cidVerifier) Verify() error {
+	sum := v.hash.Sum(nil)
+	if !bytes.Equal(sum, v.digest) {
+		return fmt.Errorf("%w: %s does not match sha256 %s", ErrBlobVerification, v.cid, hex.EncodeToString(sum))
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// Package bsky talks to the AT Protocol services behind Bluesky: the AppView for posts,
+// the PLC directory and handle resolution for identities, and each account's PDS for blobs.
+package bsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// How far up and down the thread getPostThread should walk when collecting a video series.
+const (
+	threadDepth        = 1000
+	threadParentHeight = 1000
+)
+
+// ErrPostNotFound is returned when the AppView has no post at the requested URI.
+var ErrPostNotFound = errors.New("post not found")
+
+// Client fetches posts and their media.
+type Client struct {
+	HTTPClient *http.Client // Used for blobs, CDN images, external media and short links
+	XRPC       *XRPCClient
+	Identity   *IdentityResolver
+
+	// Hosts external media may be fetched from, so the service can't be used as an open proxy
+	ExternalMediaHosts map[string]bool
+
+	MaxBlobSize int64 // Largest blob FetchBlob saves in bytes, 0 for no limit
+}
+
+// NewClient returns a client for the public Bluesky services.
+func NewClient() *Client {
+	return &Client{
+		HTTPClient:         &http.Client{Timeout: 5 * time.Minute},
+		XRPC:               NewXRPCClient(),
+		Identity:           NewIdentityResolver(),
+		ExternalMediaHosts: map[string]bool{"media.tenor.com": true},
+	}
+}
+
+// get sends a GET request that is abandoned when ctx is cancelled.
+func (c *Client) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// FetchPostMetadata fetches the metadata for the given profile and postID.
+func (c *Client) FetchPostMetadata(ctx context.Context, profile, postID string) (_ *PostDetails, err error) {
+	ctx, span := tracing.Start(ctx, "bsky.FetchPostMetadata", attribute.String("post.id", postID), attribute.String("profile", profile))
+	defer func() { tracing.End(span, err) }()
+
+	params := url.Values{}
+	params.Set("uri", PostURI(profile, postID))
+	params.Set("depth", "0")
+	slog.DebugContext(ctx, "Fetching metadata", "uri", params.Get("uri"))
+
+	// Make the API request
+	body, err := c.XRPC.Query(ctx, "app.bsky.feed.getPostThread", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+	slog.DebugContext(ctx, "Raw API response", "body", string(body))
+
+	var response map[string]interface{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	post, ok := safeExtract(response, []string{"thread", "post"}).(map[string]interface{})
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+
+	return c.parsePostView(post), nil
+}
+
+// FetchThreadVideos returns every video posted by the author of the given post
+// anywhere in its thread, ordered by creation time.
+func (c *Client) FetchThreadVideos(ctx context.Context, profile, postID string) ([]*PostDetails, error) {
+	params := url.Values{}
+	params.Set("uri", PostURI(profile, postID))
+	params.Set("depth", strconv.Itoa(threadDepth))
+	params.Set("parentHeight", strconv.Itoa(threadParentHeight))
+	slog.DebugContext(ctx, "Fetching thread", "uri", params.Get("uri"))
+
+	body, err := c.XRPC.Query(ctx, "app.bsky.feed.getPostThread", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch thread: %w", err)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	thread, ok := response["thread"].(map[string]interface{})
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+
+	authorDID, _ := safeExtract(thread, []string{"post", "author", "did"}).(string)
+	if authorDID == "" {
+		return nil, fmt.Errorf("thread author not found in response")
+	}
+
+	// Walk up through the parents, then down through every reply branch
+	var posts []map[string]interface{}
+	for parent, ok := thread["parent"].(map[string]interface{}); ok; parent, ok = parent["parent"].(map[string]interface{}) {
+		if post, ok := parent["post"].(map[string]interface{}); ok {
+			posts = append(posts, post)
+		}
+	}
+	posts = append(posts, collectThreadPosts(thread)...)
+
+	seen := make(map[string]bool)
+	var videos []*PostDetails
+	for _, post := range posts {
+		if did, _ := safeExtract(post, []string{"author", "did"}).(string); did != authorDID {
+			continue
+		}
+
+		details := c.parsePostView(post)
+		if details.Playlist == "" {
+			continue
+		}
+
+		rkey := details.RecordKey()
+		if rkey == "" || seen[rkey] {
+			continue
+		}
+		seen[rkey] = true
+		videos = append(videos, details)
+	}
+
+	// Order the series by the time each part was posted
+	sort.SliceStable(videos, func(i, j int) bool {
+		a, errA := time.Parse(time.RFC3339Nano, videos[i].CreatedAt)
+		b, errB := time.Parse(time.RFC3339Nano, videos[j].CreatedAt)
+		if errA != nil || errB != nil {
+			return videos[i].CreatedAt < videos[j].CreatedAt
+		}
+		return a.Before(b)
+	})
+
+	slog.InfoContext(ctx, "Found videos in thread", "count", len(videos), "author", authorDID)
+	return videos, nil
+}
+
+// collectThreadPosts returns the post of a thread view followed by all of its replies, depth first.
+func collectThreadPosts(node map[string]interface{}) []map[string]interface{} {
+	var posts []map[string]interface{}
+	if post, ok := node["post"].(map[string]interface{}); ok {
+		posts = append(posts, post)
+	}
+
+	replies, _ := node["replies"].([]interface{})
+	for _, reply := range replies {
+		if replyNode, ok := reply.(map[string]interface{}); ok {
+			posts = append(posts, collectThreadPosts(replyNode)...)
+		}
+	}
+	return posts
+}
+
+// FetchAuthorVideos pages through the author's feed and returns their video posts, newest first.
+func (c *Client) FetchAuthorVideos(ctx context.Context, profile string, since, until time.Time, maxCount int) ([]*PostDetails, error) {
+	var videos []*PostDetails
+	cursor := ""
+
+	for {
+		params := url.Values{}
+		params.Set("actor", profile)
+		params.Set("filter", "posts_with_video")
+		params.Set("limit", "100")
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+
+		slog.DebugContext(ctx, "Fetching author feed", "profile", profile, "cursor", cursor)
+
+		body, err := c.XRPC.Query(ctx, "app.bsky.feed.getAuthorFeed", params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch author feed: %w", err)
+		}
+
+		var page struct {
+			Cursor string `json:"cursor"`
+			Feed   []struct {
+				Post   map[string]interface{} `json:"post"`
+				Reason map[string]interface{} `json:"reason"`
+			} `json:"feed"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		for _, item := range page.Feed {
+			// The feed is ordered by when its items were indexed, newest first, so nothing
+			// after an item indexed before since is in range. A repost is placed by when
+			// the repost was indexed.
+			indexedAt := extractString(item.Post, "indexedAt")
+			if item.Reason != nil {
+				indexedAt = extractString(item.Reason, "indexedAt")
+			}
+			if indexed, err := time.Parse(time.RFC3339Nano, indexedAt); err == nil && !since.IsZero() && indexed.Before(since) {
+				return videos, nil
+			}
+
+			// Reposts belong to someone else
+			if item.Reason != nil {
+				continue
+			}
+
+			details := c.parsePostView(item.Post)
+			if details.Playlist == "" {
+				continue
+			}
+
+			createdAt, err := time.Parse(time.RFC3339Nano, details.CreatedAt)
+			if err != nil {
+				slog.WarnContext(ctx, "Skipping post with invalid createdAt", "createdAt", details.CreatedAt)
+				continue
+			}
+
+			// createdAt is set by the author's client and needn't follow the feed's order
+			if !since.IsZero() && createdAt.Before(since) {
+				continue
+			}
+			if !until.IsZero() && !createdAt.Before(until) {
+				continue
+			}
+
+			videos = append(videos, details)
+			if maxCount > 0 && len(videos) >= maxCount {
+				return videos, nil
+			}
+		}
+
+		if page.Cursor == "" || len(page.Feed) == 0 {
+			return videos, nil
+		}
+		cursor = page.Cursor
+	}
+}
@@ -0,0 +1,78 @@
+package bsky
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// feedItem is an author feed entry for a video post, a repost if repostedAt is set.
+func feedItem(rkey, createdAt, indexedAt, repostedAt string) map[string]interface{} {
+	item := map[string]interface{}{
+		"post": map[string]interface{}{
+			"uri":       "at://did:plc:alice/app.bsky.feed.post/" + rkey,
+			"author":    map[string]interface{}{"did": "did:plc:alice", "handle": "alice.test"},
+			"record":    map[string]interface{}{"createdAt": createdAt},
+			"indexedAt": indexedAt,
+			"embed": map[string]interface{}{
+				"$type":    "app.bsky.embed.video#view",
+				"playlist": "https://video.test/" + rkey + "/playlist.m3u8",
+			},
+		},
+	}
+	if repostedAt != "" {
+		item["reason"] = map[string]interface{}{"$type": "app.bsky.feed.defs#reasonRepost", "indexedAt": repostedAt}
+	}
+	return item
+}
+
+func TestFetchAuthorVideosFiltersByCreatedAt(t *testing.T) {
+	pages := map[string]map[string]interface{}{
+		"": {"cursor": "2", "feed": []interface{}{
+			feedItem("new", "2024-06-10T00:00:00Z", "2024-06-10T00:00:00Z", ""),
+			feedItem("backdated", "2020-01-01T00:00:00Z", "2024-06-09T00:00:00Z", ""),
+			feedItem("reposted", "2019-01-01T00:00:00Z", "2019-01-01T00:00:00Z", "2024-06-08T00:00:00Z"),
+			feedItem("future", "2030-01-01T00:00:00Z", "2024-06-07T00:00:00Z", ""),
+		}},
+		"2": {"cursor": "3", "feed": []interface{}{
+			feedItem("older", "2024-06-06T00:00:00Z", "2024-06-06T00:00:00Z", ""),
+			feedItem("before", "2024-06-04T00:00:00Z", "2024-06-04T00:00:00Z", ""),
+			feedItem("unreached", "2024-06-08T00:00:00Z", "2024-06-03T00:00:00Z", ""),
+		}},
+	}
+	requested := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested++
+		page, ok := pages[r.URL.Query().Get("cursor")]
+		if !ok {
+			t.Errorf("unexpected cursor %q", r.URL.Query().Get("cursor"))
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+
+	c := NewClient()
+	c.XRPC.AppViewURL = server.URL
+	since := time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	videos, err := c.FetchAuthorVideos(context.Background(), "did:plc:alice", since, until, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backdated post doesn't end the scan, the post indexed before since does
+	var got []string
+	for _, video := range videos {
+		got = append(got, video.RecordKey())
+	}
+	if len(got) != 2 || got[0] != "new" || got[1] != "older" {
+		t.Errorf("videos = %v, want [new older]", got)
+	}
+	if requested != 2 {
+		t.Errorf("fetched %d pages, want 2", requested)
+	}
+}
@@ -0,0 +1,150 @@
+package bsky
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Caption is a subtitle track attached to a video, either as a blob in the
+// app.bsky.embed.video record or as a subtitle rendition in the HLS master playlist.
+type Caption struct {
+	Lang   string `json:"lang"`
+	Source string `json:"source"` // "record" or "hls"
+	Cid    string `json:"-"`
+	URI    string `json:"-"`
+}
+
+type ImageDetails struct {
+	Fullsize    string       `json:"fullsize"`
+	Thumb       string       `json:"thumb"`
+	Alt         string       `json:"alt"`
+	AspectRatio *AspectRatio `json:"aspectRatio,omitempty"`
+}
+
+type ExternalMedia struct {
+	URI         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Thumb       string `json:"thumb"`
+}
+
+// videoEmbed returns the video view of a post, including videos attached to quote posts.
+func videoEmbed(post map[string]interface{}) map[string]interface{} {
+	embed, ok := post["embed"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch embed["$type"] {
+	case "app.bsky.embed.video#view":
+		return embed
+	case "app.bsky.embed.recordWithMedia#view":
+		if media, ok := embed["media"].(map[string]interface{}); ok && media["$type"] == "app.bsky.embed.video#view" {
+			return media
+		}
+	}
+	return nil
+}
+
+// imagesEmbed returns the images view of a post, including images attached to quote posts.
+func imagesEmbed(post map[string]interface{}) map[string]interface{} {
+	embed, ok := post["embed"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch embed["$type"] {
+	case "app.bsky.embed.images#view":
+		return embed
+	case "app.bsky.embed.recordWithMedia#view":
+		if media, ok := embed["media"].(map[string]interface{}); ok && media["$type"] == "app.bsky.embed.images#view" {
+			return media
+		}
+	}
+	return nil
+}
+
+func parseImages(embed map[string]interface{}) []ImageDetails {
+	items, _ := embed["images"].([]interface{})
+
+	var images []ImageDetails
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		image := ImageDetails{
+			Fullsize: extractString(m, "fullsize"),
+			Thumb:    extractString(m, "thumb"),
+			Alt:      extractString(m, "alt"),
+		}
+		if _, ok := m["aspectRatio"]; ok {
+			image.AspectRatio = &AspectRatio{
+				Height: extractInt(m, "aspectRatio", "height"),
+				Width:  extractInt(m, "aspectRatio", "width"),
+			}
+		}
+		if image.Fullsize != "" {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// externalEmbed returns the external link of a post if it points at media on an allowed host.
+func (c *Client) externalEmbed(post map[string]interface{}) *ExternalMedia {
+	embed, ok := post["embed"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if embed["$type"] == "app.bsky.embed.recordWithMedia#view" {
+		embed, _ = embed["media"].(map[string]interface{})
+	}
+	if embed == nil || embed["$type"] != "app.bsky.embed.external#view" {
+		return nil
+	}
+
+	external := &ExternalMedia{
+		URI:         extractString(embed, "external", "uri"),
+		Title:       extractString(embed, "external", "title"),
+		Description: extractString(embed, "external", "description"),
+		Thumb:       extractString(embed, "external", "thumb"),
+	}
+
+	u, err := url.Parse(external.URI)
+	if err != nil || !c.isAllowedExternalURL(u) {
+		return nil
+	}
+	return external
+}
+
+func (c *Client) isAllowedExternalURL(u *url.URL) bool {
+	return u.Scheme == "https" && c.ExternalMediaHosts[strings.ToLower(u.Hostname())]
+}
+
+// recordCaptions returns the caption blobs referenced by the post's video embed record.
+func recordCaptions(post map[string]interface{}) []Caption {
+	embed, _ := safeExtract(post, []string{"record", "embed"}).(map[string]interface{})
+	if embed != nil && embed["$type"] == "app.bsky.embed.recordWithMedia" {
+		embed, _ = embed["media"].(map[string]interface{})
+	}
+	if embed == nil || embed["$type"] != "app.bsky.embed.video" {
+		return nil
+	}
+
+	items, _ := embed["captions"].([]interface{})
+	var captions []Caption
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		lang, _ := m["lang"].(string)
+		cid, _ := safeExtract(m, []string{"file", "ref", "$link"}).(string)
+		if lang != "" && cid != "" {
+			captions = append(captions, Caption{Lang: lang, Source: "record", Cid: cid})
+		}
+	}
+	return captions
+}
@@ -0,0 +1,326 @@
+package bsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+)
+
+// ErrHandleNotFound is returned when a handle definitely doesn't resolve, and ErrDIDNotFound
+// when the DID has no document. ErrInvalidIdentifier means the input isn't a handle or DID
+// at all. Other resolution errors mean the services couldn't be reached.
+var (
+	ErrHandleNotFound    = errors.New("handle not found")
+	ErrDIDNotFound       = errors.New("DID not found")
+	ErrInvalidIdentifier = errors.New("invalid handle or DID")
+)
+
+var (
+	handleRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	didRegex    = regexp.MustCompile(`^did:[a-z]+:[a-zA-Z0-9._:%-]*[a-zA-Z0-9._-]$`)
+)
+
+type DIDDocument struct {
+	ID          string       `json:"id"`
+	AlsoKnownAs []string     `json:"alsoKnownAs"`
+	Service     []DIDService `json:"service"`
+}
+
+type DIDService struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// IdentityResolver turns handles into DIDs and DIDs into DID documents.
+// Every endpoint can be pointed at a local stand-in.
+type IdentityResolver struct {
+	HTTPClient       *http.Client
+	ResolveHandleURL string                                                   // XRPC service implementing com.atproto.identity.resolveHandle
+	PLCDirectoryURL  string                                                   // PLC directory used for did:plc
+	LookupTXT        func(ctx context.Context, name string) ([]string, error) // DNS TXT lookup for _atproto.<handle>
+	WellKnownScheme  string                                                   // Scheme used for /.well-known requests and did:web
+	TTL              time.Duration                                            // How long handles and DID documents are cached
+	MaxCached        int                                                      // Entries each cache holds at most, unbounded if 0
+
+	handles ttlCache[string]
+	docs    ttlCache[*DIDDocument]
+}
+
+// NewIdentityResolver returns a resolver that talks to the public Bluesky services.
+func NewIdentityResolver() *IdentityResolver {
+	return &IdentityResolver{
+		HTTPClient:       &http.Client{Timeout: 10 * time.Second},
+		ResolveHandleURL: "https://public.api.bsky.app",
+		PLCDirectoryURL:  "https://plc.directory",
+		LookupTXT:        net.DefaultResolver.LookupTXT,
+		WellKnownScheme:  "https",
+		TTL:              time.Hour,
+		MaxCached:        10000,
+	}
+}
+
+// ResolveIdentifier returns the DID for a handle or DID.
+func (ir *IdentityResolver) ResolveIdentifier(ctx context.Context, identifier string) (string, error) {
+	identifier = strings.TrimPrefix(identifier, "@")
+	if !isValidActor(identifier) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, identifier)
+	}
+	if strings.HasPrefix(identifier, "did:") {
+		return identifier, nil
+	}
+	return ir.ResolveHandle(ctx, identifier)
+}
+
+// isValidActor reports whether actor is a syntactically valid handle or DID. Anything
+// else must not reach the DNS lookups or the well-known URL built from it.
+func isValidActor(actor string) bool {
+	if strings.HasPrefix(actor, "did:") {
+		return len(actor) <= 2048 && didRegex.MatchString(actor)
+	}
+	return len(actor) <= 253 && handleRegex.MatchString(actor)
+}
+
+// ResolveHandle resolves a handle through com.atproto.identity.resolveHandle,
+// falling back to the DNS TXT record and the /.well-known/atproto-did file. The
+// DID's document must claim the handle back before the result is accepted.
+func (ir *IdentityResolver) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	if strings.HasPrefix(handle, "did:") || !isValidActor(handle) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, handle)
+	}
+	handle = strings.ToLower(handle)
+
+	did, hit := ir.handles.get(handle)
+	metrics.CacheLookup("handle", hit)
+	if hit {
+		return did, nil
+	}
+
+	resolvers := []struct {
+		name    string
+		resolve func(context.Context, string) (string, error)
+	}{
+		{"resolveHandle", ir.resolveHandleXRPC},
+		{"DNS TXT", ir.resolveHandleDNS},
+		{"well-known", ir.resolveHandleWellKnown},
+	}
+
+	// The handle only counts as not found if resolveHandle says so or every method does,
+	// as an outage of any of them could hide it
+	var errs []string
+	notFound := make([]bool, len(resolvers))
+	for i, resolver := range resolvers {
+		did, err := resolver.resolve(ctx, handle)
+		if err == nil && !strings.HasPrefix(did, "did:") {
+			err = fmt.Errorf("%w: invalid DID %q", ErrHandleNotFound, did)
+		}
+		if err == nil {
+			err = ir.verifyHandle(ctx, handle, did)
+		}
+		if err == nil {
+			slog.DebugContext(ctx, "Resolved handle", "handle", handle, "did", did, "via", resolver.name)
+			ir.handles.put(handle, did, ir.TTL, ir.MaxCached)
+			return did, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		notFound[i] = errors.Is(err, ErrHandleNotFound)
+		errs = append(errs, fmt.Sprintf("%s: %v", resolver.name, err))
+	}
+
+	if notFound[0] || !slices.Contains(notFound, false) {
+		return "", fmt.Errorf("%w: %s (%s)", ErrHandleNotFound, handle, strings.Join(errs, "; "))
+	}
+	return "", fmt.Errorf("failed to resolve handle %s (%s)", handle, strings.Join(errs, "; "))
+}
+
+// verifyHandle checks that the DID document lists the handle in alsoKnownAs, so one
+// method answering with someone else's DID can't make the handle resolve to it.
+func (ir *IdentityResolver) verifyHandle(ctx context.Context, handle, did string) error {
+	doc, err := ir.ResolveDID(ctx, did)
+	if errors.Is(err, ErrDIDNotFound) {
+		return fmt.Errorf("%w: %v", ErrHandleNotFound, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, aka := range doc.AlsoKnownAs {
+		if strings.EqualFold(aka, "at://"+handle) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: DID document of %s does not claim the handle", ErrHandleNotFound, did)
+}
+
+func (ir *IdentityResolver) resolveHandleXRPC(ctx context.Context, handle string) (string, error) {
+	apiURL := fmt.Sprintf("%s/xrpc/com.atproto.identity.resolveHandle?handle=%s", ir.ResolveHandleURL, url.QueryEscape(handle))
+
+	var response struct {
+		DID string `json:"did"`
+	}
+	if err := ir.getJSON(ctx, apiURL, &response); err != nil {
+		// The service answers 400 for handles it can't resolve
+		if statusIn(err, http.StatusBadRequest, http.StatusNotFound) {
+			return "", fmt.Errorf("%w: %v", ErrHandleNotFound, err)
+		}
+		return "", err
+	}
+	return response.DID, nil
+}
+
+func (ir *IdentityResolver) resolveHandleDNS(ctx context.Context, handle string) (string, error) {
+	records, err := ir.LookupTXT(ctx, "_atproto."+handle)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", fmt.Errorf("%w: %v", ErrHandleNotFound, err)
+		}
+		return "", err
+	}
+	for _, record := range records {
+		if did, ok := strings.CutPrefix(record, "did="); ok {
+			return did, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no did= TXT record found", ErrHandleNotFound)
+}
+
+func (ir *IdentityResolver) resolveHandleWellKnown(ctx context.Context, handle string) (string, error) {
+	resp, err := ir.get(ctx, fmt.Sprintf("%s://%s/.well-known/atproto-did", ir.WellKnownScheme, handle))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", fmt.Errorf("%w: %v", ErrHandleNotFound, err)
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return "", fmt.Errorf("%w: returned status code: %d", ErrHandleNotFound, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("returned status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ResolveDID fetches the DID document for a did:plc or did:web identity.
+func (ir *IdentityResolver) ResolveDID(ctx context.Context, did string) (*DIDDocument, error) {
+	cached, hit := ir.docs.get(did)
+	metrics.CacheLookup("did_document", hit)
+	if hit {
+		return cached, nil
+	}
+
+	var docURL string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		docURL = fmt.Sprintf("%s/%s", ir.PLCDirectoryURL, did)
+	case strings.HasPrefix(did, "did:web:"):
+		// The host may carry a percent-encoded port, further segments form a path
+		parts := strings.Split(strings.TrimPrefix(did, "did:web:"), ":")
+		host, err := url.PathUnescape(parts[0])
+		if err != nil || host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("%w: invalid did:web identifier: %s", ErrDIDNotFound, did)
+		}
+		docURL = fmt.Sprintf("%s://%s/.well-known/did.json", ir.WellKnownScheme, host)
+		if len(parts) > 1 {
+			docURL = fmt.Sprintf("%s://%s/%s/did.json", ir.WellKnownScheme, host, strings.Join(parts[1:], "/"))
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported DID method: %s", ErrDIDNotFound, did)
+	}
+
+	var doc DIDDocument
+	if err := ir.getJSON(ctx, docURL, &doc); err != nil {
+		// The PLC directory answers 410 for deactivated identities
+		if statusIn(err, http.StatusNotFound, http.StatusGone) {
+			return nil, fmt.Errorf("%w: %s: %v", ErrDIDNotFound, did, err)
+		}
+		return nil, fmt.Errorf("failed to resolve DID document for %s: %v", did, err)
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("%w: DID document id %s does not match %s", ErrDIDNotFound, doc.ID, did)
+	}
+
+	ir.docs.put(did, &doc, ir.TTL, ir.MaxCached)
+	return &doc, nil
+}
+
+func (ir *IdentityResolver) get(ctx context.Context, apiURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ir.HTTPClient.Do(req)
+}
+
+func (ir *IdentityResolver) getJSON(ctx context.Context, apiURL string, v interface{}) error {
+	resp, err := ir.get(ctx, apiURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{status: resp.StatusCode, body: string(body)}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// statusError is returned by getJSON for responses other than 200 OK.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("returned status: %v, response: %s", e.status, e.body)
+}
+
+// statusIn reports whether err is a statusError with one of the given statuses.
+func statusIn(err error, statuses ...int) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && slices.Contains(statuses, statusErr.status)
+}
+
+// PostURI builds the canonical AT-URI of a post.
+func PostURI(did, postID string) string {
+	return fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, postID)
+}
+
+// PDSEndpoint returns the URL of the PDS hosting the given DID's repository.
+func (ir *IdentityResolver) PDSEndpoint(ctx context.Context, did string) (string, error) {
+	doc, err := ir.ResolveDID(ctx, did)
+	if err != nil {
+		return "", err
+	}
+
+	for _, service := range doc.Service {
+		if (service.ID == "#atproto_pds" || service.ID == did+"#atproto_pds") && service.Type == "AtprotoPersonalDataServer" {
+			return strings.TrimSuffix(service.ServiceEndpoint, "/"), nil
+		}
+	}
+	return "", fmt.Errorf("no PDS endpoint found in DID document for %s", did)
+}
@@ -0,0 +1,275 @@
+package bsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// standIn plays every service the resolver talks to: the resolveHandle XRPC endpoint,
+// the PLC directory and, by routing every host to it, each handle's domain.
+type standIn struct {
+	mu        sync.Mutex
+	xrpc      map[string]string // Handle to DID, or "!<status>" to fail with that status
+	wellKnown map[string]string // Host to /.well-known/atproto-did body, or "!<status>"
+	docs      map[string]string // PLC DID or did:web path (host/path/did.json) to document
+	requests  map[string]int    // Requests per path
+}
+
+func newStandIn(t *testing.T) (*standIn, *IdentityResolver) {
+	s := &standIn{
+		xrpc:      make(map[string]string),
+		wellKnown: make(map[string]string),
+		docs:      make(map[string]string),
+		requests:  make(map[string]int),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	// Send every host to the stand-in, whatever the DNS says
+	addr := server.Listener.Addr().String()
+	transport := &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}}
+
+	ir := NewIdentityResolver()
+	ir.HTTPClient = &http.Client{Transport: transport}
+	ir.ResolveHandleURL = server.URL
+	ir.PLCDirectoryURL = server.URL + "/plc"
+	ir.WellKnownScheme = "http"
+	ir.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return s, ir
+}
+
+// account registers a did:plc document claiming handle, served through the PLC directory.
+func (s *standIn) account(did, handle, pds string) {
+	s.docs[did] = fmt.Sprintf(`{"id":%q,"alsoKnownAs":["at://%s"],"service":[{"id":"#atproto_pds","type":"AtprotoPersonalDataServer","serviceEndpoint":%q}]}`, did, handle, pds)
+}
+
+func (s *standIn) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+
+	respond := func(body string, ok bool) {
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var status int
+		if _, err := fmt.Sscanf(body, "!%d", &status); err == nil {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"Unable to resolve handle"}`))
+			return
+		}
+		w.Write([]byte(body))
+	}
+	host, _, _ := strings.Cut(r.Host, ":")
+
+	switch {
+	case r.URL.Path == "/xrpc/com.atproto.identity.resolveHandle":
+		did, ok := s.xrpc[r.URL.Query().Get("handle")]
+		if ok && !strings.HasPrefix(did, "!") {
+			did = fmt.Sprintf(`{"did":%q}`, did)
+		}
+		if !ok {
+			did = "!400"
+		}
+		respond(did, true)
+	case strings.HasPrefix(r.URL.Path, "/plc/"):
+		doc, ok := s.docs[strings.TrimPrefix(r.URL.Path, "/plc/")]
+		respond(doc, ok)
+	case r.URL.Path == "/.well-known/atproto-did":
+		body, ok := s.wellKnown[host]
+		respond(body, ok)
+	default:
+		doc, ok := s.docs[host+r.URL.Path]
+		respond(doc, ok)
+	}
+}
+
+func TestResolveHandleXRPC(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["alice.test"] = "did:plc:alice"
+	s.account("did:plc:alice", "alice.test", "https://pds.test")
+
+	for i := 0; i < 2; i++ {
+		did, err := ir.ResolveIdentifier(context.Background(), "@Alice.test")
+		if err != nil || did != "did:plc:alice" {
+			t.Fatalf("ResolveIdentifier = %q, %v, want did:plc:alice", did, err)
+		}
+	}
+	if n := s.count("/xrpc/com.atproto.identity.resolveHandle"); n != 1 {
+		t.Errorf("resolveHandle called %d times, want 1 as the result is cached", n)
+	}
+}
+
+func TestResolveHandleFallsBackToDNS(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["bob.test"] = "!502"
+	s.account("did:plc:bob", "bob.test", "https://pds.test")
+	ir.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if name != "_atproto.bob.test" {
+			t.Errorf("looked up TXT record %s", name)
+		}
+		return []string{"v=spf1 -all", "did=did:plc:bob"}, nil
+	}
+
+	did, err := ir.ResolveHandle(context.Background(), "bob.test")
+	if err != nil || did != "did:plc:bob" {
+		t.Fatalf("ResolveHandle = %q, %v, want did:plc:bob", did, err)
+	}
+}
+
+func TestResolveHandleFallsBackToWellKnown(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["carol.test"] = "!502"
+	s.wellKnown["carol.test"] = "did:plc:carol\n"
+	s.account("did:plc:carol", "carol.test", "https://pds.test")
+
+	did, err := ir.ResolveHandle(context.Background(), "carol.test")
+	if err != nil || did != "did:plc:carol" {
+		t.Fatalf("ResolveHandle = %q, %v, want did:plc:carol", did, err)
+	}
+}
+
+func TestResolveHandleRejectsUnclaimedDID(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["mallory.test"] = "!502"
+	s.wellKnown["mallory.test"] = "did:plc:alice"
+	s.account("did:plc:alice", "alice.test", "https://pds.test")
+
+	_, err := ir.ResolveHandle(context.Background(), "mallory.test")
+	if err == nil {
+		t.Fatal("ResolveHandle accepted a DID whose document doesn't claim the handle")
+	}
+	if ir.handles.len() != 0 {
+		t.Error("the rejected DID was cached")
+	}
+}
+
+func TestResolveHandleNotFound(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["nobody.test"] = "!400"
+
+	_, err := ir.ResolveHandle(context.Background(), "nobody.test")
+	if !errors.Is(err, ErrHandleNotFound) {
+		t.Fatalf("ResolveHandle error = %v, want ErrHandleNotFound", err)
+	}
+}
+
+func TestResolveHandleOutageIsNotNotFound(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.xrpc["dave.test"] = "!503"
+	ir.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+
+	_, err := ir.ResolveHandle(context.Background(), "dave.test")
+	if err == nil || errors.Is(err, ErrHandleNotFound) {
+		t.Fatalf("ResolveHandle error = %v, want an error other than ErrHandleNotFound", err)
+	}
+}
+
+func TestResolveDIDPLC(t *testing.T) {
+	s, ir := newStandIn(t)
+	s.account("did:plc:alice", "alice.test", "https://pds.test/")
+
+	pds, err := ir.PDSEndpoint(context.Background(), "did:plc:alice")
+	if err != nil || pds != "https://pds.test" {
+		t.Fatalf("PDSEndpoint = %q, %v, want https://pds.test", pds, err)
+	}
+}
+
+func TestResolveDIDWeb(t *testing.T) {
+	s, ir := newStandIn(t)
+	doc := func(did string) string {
+		b, _ := json.Marshal(map[string]interface{}{
+			"id":      did,
+			"service": []map[string]string{{"id": did + "#atproto_pds", "type": "AtprotoPersonalDataServer", "serviceEndpoint": "https://" + did}},
+		})
+		return string(b)
+	}
+	// The stand-in ignores the port, the path must still come from the remaining segments
+	s.docs["web.test/.well-known/did.json"] = doc("did:web:web.test")
+	s.docs["web.test/users/erin/did.json"] = doc("did:web:web.test%3A8443:users:erin")
+
+	for _, did := range []string{"did:web:web.test", "did:web:web.test%3A8443:users:erin"} {
+		pds, err := ir.PDSEndpoint(context.Background(), did)
+		if err != nil || pds != "https://"+did {
+			t.Errorf("PDSEndpoint(%s) = %q, %v", did, pds, err)
+		}
+	}
+}
+
+func TestResolveDIDNotFound(t *testing.T) {
+	_, ir := newStandIn(t)
+
+	for _, did := range []string{"did:plc:gone", "did:example:123", "did:web:"} {
+		if _, err := ir.ResolveDID(context.Background(), did); !errors.Is(err, ErrDIDNotFound) {
+			t.Errorf("ResolveDID(%s) error = %v, want ErrDIDNotFound", did, err)
+		}
+	}
+}
+
+func TestIdentityCacheIsBounded(t *testing.T) {
+	s, ir := newStandIn(t)
+	ir.MaxCached = 2
+	for _, name := range []string{"a", "b", "c"} {
+		handle, did := name+".test", "did:plc:"+name
+		s.xrpc[handle] = did
+		s.account(did, handle, "https://pds.test")
+		if _, err := ir.ResolveHandle(context.Background(), handle); err != nil {
+			t.Fatalf("ResolveHandle(%s): %v", handle, err)
+		}
+	}
+
+	if n := ir.handles.len(); n != 2 {
+		t.Errorf("handle cache holds %d entries, want 2", n)
+	}
+	if n := ir.docs.len(); n != 2 {
+		t.Errorf("document cache holds %d entries, want 2", n)
+	}
+}
+
+func TestResolveIdentifierRejectsInvalidInput(t *testing.T) {
+	s, ir := newStandIn(t)
+	lookups := 0
+	ir.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		lookups++
+		return nil, nil
+	}
+
+	for _, identifier := range []string{
+		"", "alice", "169.254.169.254/latest", "alice.test/../admin", "alice.test:8080",
+		"alice.test?x=1", "did:", "did:plc:", "did:web:evil.test/path", "did:plc:a b",
+	} {
+		if _, err := ir.ResolveIdentifier(context.Background(), identifier); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("ResolveIdentifier(%q) error = %v, want ErrInvalidIdentifier", identifier, err)
+		}
+	}
+	if _, err := ir.ResolveHandle(context.Background(), "did:plc:alice"); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Errorf("ResolveHandle(DID) error = %v, want ErrInvalidIdentifier", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) != 0 || lookups != 0 {
+		t.Errorf("invalid identifiers made %d requests and %d DNS lookups, want none", len(s.requests), lookups)
+	}
+}
@@ -0,0 +1,190 @@
+package bsky
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Label struct {
+	Src string `json:"src"`
+	Val string `json:"val"`
+	Neg bool   `json:"neg,omitempty"`
+}
+
+type LabelAction string
+
+// Actions are ordered from least to most restrictive.
+const (
+	LabelAllow       LabelAction = "allow"
+	LabelWarn        LabelAction = "warn"
+	LabelAcknowledge LabelAction = "acknowledge" // Download only with acknowledgeLabels set
+	LabelRefuse      LabelAction = "refuse"
+)
+
+var labelActionRank = map[LabelAction]int{
+	LabelAllow:       0,
+	LabelWarn:        1,
+	LabelAcknowledge: 2,
+	LabelRefuse:      3,
+}
+
+// LabelPolicy maps label values to the action taken when a post or its author carries them.
+type LabelPolicy struct {
+	Default LabelAction            `json:"default"`
+	Labels  map[string]LabelAction `json:"labels"`
+}
+
+type LabelDecision struct {
+	Action  LabelAction `json:"action"`
+	Labels  []string    `json:"labels"`
+	Reasons []string    `json:"reasons"`
+}
+
+// DefaultLabelPolicy refuses taken down posts and asks for acknowledgement of adult content.
+// Without an authenticated session it also refuses posts their authors only show to
+// logged-in users.
+func DefaultLabelPolicy(authenticated bool) *LabelPolicy {
+	policy := &LabelPolicy{
+		Default: LabelAllow,
+		Labels: map[string]LabelAction{
+			"!takedown":     LabelRefuse,
+			"!suspend":      LabelRefuse,
+			"!hide":         LabelRefuse,
+			"!warn":         LabelWarn,
+			"porn":          LabelAcknowledge,
+			"sexual":        LabelAcknowledge,
+			"nudity":        LabelAcknowledge,
+			"graphic-media": LabelAcknowledge,
+			"gore":          LabelAcknowledge,
+		},
+	}
+	if !authenticated {
+		policy.Labels["!no-unauthenticated"] = LabelRefuse
+	}
+	return policy
+}
+
+// LoadLabelPolicy reads a policy from a JSON file. Labels not listed keep their default
+// action for an authenticated session or not.
+func LoadLabelPolicy(path string, authenticated bool) (*LabelPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read label policy: %v", err)
+	}
+
+	var custom LabelPolicy
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse label policy: %v", err)
+	}
+
+	policy := DefaultLabelPolicy(authenticated)
+	if custom.Default != "" {
+		policy.Default = custom.Default
+	}
+	for val, action := range custom.Labels {
+		policy.Labels[val] = action
+	}
+
+	if _, ok := labelActionRank[policy.Default]; !ok {
+		return nil, fmt.Errorf("invalid default label action: %s", policy.Default)
+	}
+	for val, action := range policy.Labels {
+		if _, ok := labelActionRank[action]; !ok {
+			return nil, fmt.Errorf("invalid action %q for label %s", action, val)
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate returns the most restrictive action triggered by the post's or author's labels.
+func (p *LabelPolicy) Evaluate(postLabels, authorLabels []Label) LabelDecision {
+	decision := LabelDecision{Action: LabelAllow, Labels: []string{}, Reasons: []string{}}
+
+	apply := func(labels []Label, subject string) {
+		for _, val := range activeLabels(labels) {
+			action, ok := p.Labels[val]
+			if !ok {
+				action = p.Default
+			}
+			if action == LabelAllow {
+				continue
+			}
+
+			decision.Labels = append(decision.Labels, val)
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("%s is labeled %q", subject, val))
+			if labelActionRank[action] > labelActionRank[decision.Action] {
+				decision.Action = action
+			}
+		}
+	}
+	apply(postLabels, "post")
+	apply(authorLabels, "author account")
+
+	return decision
+}
+
+// Check returns an error explaining why the download can't go ahead.
+func (d LabelDecision) Check(acknowledged bool) error {
+	reason := strings.Join(d.Reasons, ", ")
+	switch {
+	case d.Action == LabelRefuse:
+		return fmt.Errorf("download refused: %s", reason)
+	case d.Action == LabelAcknowledge && !acknowledged:
+		return fmt.Errorf("download requires acknowledgement (set acknowledgeLabels): %s", reason)
+	}
+	return nil
+}
+
+// activeLabels returns the distinct label values that haven't been negated by their source.
+func activeLabels(labels []Label) []string {
+	active := make(map[string]bool)
+	var order []string
+	for _, label := range labels {
+		key := label.Src + " " + label.Val
+		if label.Neg {
+			delete(active, key)
+			continue
+		}
+		if _, ok := active[key]; !ok {
+			order = append(order, key)
+		}
+		active[key] = true
+	}
+
+	seen := make(map[string]bool)
+	var vals []string
+	for _, key := range order {
+		val := key[strings.Index(key, " ")+1:]
+		if active[key] && !seen[val] {
+			seen[val] = true
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
+// postLabels extracts the labels of a post view and of its author.
+func postLabels(post map[string]interface{}) ([]Label, []Label) {
+	return parseLabels(post["labels"]), parseLabels(safeExtract(post, []string{"author", "labels"}))
+}
+
+func parseLabels(value interface{}) []Label {
+	items, _ := value.([]interface{})
+
+	var labels []Label
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		src, _ := m["src"].(string)
+		val, _ := m["val"].(string)
+		neg, _ := m["neg"].(bool)
+		if val != "" {
+			labels = append(labels, Label{Src: src, Val: val, Neg: neg})
+		}
+	}
+	return labels
+}
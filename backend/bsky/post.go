@@ -0,0 +1,136 @@
+package bsky
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+type PostDetails struct {
+	URI          string `json:"uri"`
+	URL          string `json:"url"`
+	MediaType    string `json:"mediaType"` // "video", "images", "external" or empty when the post has no media
+	AuthorDID    string `json:"authorDID"`
+	Handle       string `json:"handle"`
+	DisplayName  string `json:"displayName"`
+	CreatedAt    string `json:"createdAt"`
+	Cid          string `json:"cid"`
+	Playlist     string `json:"playlist"`
+	Thumbnail    string `json:"thumbnail"`
+	Alt          string `json:"alt"`
+	Title        string `json:"title"`
+	LikeCount    int    `json:"likeCount"`
+	ReplyCount   int    `json:"replyCount"`
+	RepostCount  int    `json:"repostCount"`
+	QuoteCount   int    `json:"quoteCount"`
+	AspectRatio  *AspectRatio
+	Labels       []Label        `json:"labels"`
+	AuthorLabels []Label        `json:"authorLabels"`
+	Captions     []Caption      `json:"captions"`
+	Images       []ImageDetails `json:"images"`
+	External     *ExternalMedia `json:"external"`
+
+	Post map[string]interface{} `json:"-"` // The post view as returned by the AppView
+}
+
+type AspectRatio struct {
+	Height int `json:"height"`
+	Width  int `json:"width"`
+}
+
+// RecordKey returns the record key of the post, which is used as its ID.
+func (p *PostDetails) RecordKey() string {
+	return p.URI[strings.LastIndex(p.URI, "/")+1:]
+}
+
+// parsePostView extracts the details of a post view returned by the AppView.
+func (c *Client) parsePostView(post map[string]interface{}) *PostDetails {
+	postDetails := &PostDetails{
+		URI:         extractString(post, "uri"),
+		AuthorDID:   extractString(post, "author", "did"),
+		Handle:      extractString(post, "author", "handle"),
+		DisplayName: extractString(post, "author", "displayName"),
+		CreatedAt:   extractString(post, "record", "createdAt"),
+		Title:       extractString(post, "record", "text"),
+		LikeCount:   extractInt(post, "likeCount"),
+		ReplyCount:  extractInt(post, "replyCount"),
+		RepostCount: extractInt(post, "repostCount"),
+		QuoteCount:  extractInt(post, "quoteCount"),
+		Post:        post,
+	}
+
+	// Link to the post on the web, preferring the handle for readability
+	author := postDetails.Handle
+	if author == "" || author == "handle.invalid" {
+		author = postDetails.AuthorDID
+	}
+	postDetails.URL = fmt.Sprintf("https://bsky.app/profile/%s/post/%s", author, postDetails.RecordKey())
+
+	if external := c.externalEmbed(post); external != nil {
+		postDetails.External = external
+		postDetails.MediaType = "external"
+	}
+
+	if embed := imagesEmbed(post); embed != nil {
+		postDetails.Images = parseImages(embed)
+		if len(postDetails.Images) > 0 {
+			postDetails.MediaType = "images"
+		}
+	}
+
+	if embed := videoEmbed(post); embed != nil {
+		postDetails.MediaType = "video"
+		postDetails.Cid = extractString(embed, "cid")
+		postDetails.Playlist = extractString(embed, "playlist")
+		postDetails.Thumbnail = extractString(embed, "thumbnail")
+		postDetails.Alt = extractString(embed, "alt")
+		postDetails.AspectRatio = &AspectRatio{
+			Height: extractInt(embed, "aspectRatio", "height"),
+			Width:  extractInt(embed, "aspectRatio", "width"),
+		}
+	}
+
+	postDetails.Labels, postDetails.AuthorLabels = postLabels(post)
+	postDetails.Captions = recordCaptions(post)
+	return postDetails
+}
+
+func extractString(m map[string]interface{}, keys ...string) string {
+	value, _ := safeExtract(m, keys).(string)
+	return value
+}
+
+func extractInt(m map[string]interface{}, keys ...string) int {
+	value, _ := safeExtract(m, keys).(float64)
+	return int(value)
+}
+
+// Recursive function to extract nested values
+func safeExtract(m map[string]interface{}, keys []string) interface{} {
+	current := m
+	for i, key := range keys {
+		value, exists := current[key]
+		if !exists {
+			slog.Debug("Key path not found", "path", keys[:i+1], "missing", key)
+			return nil
+		}
+		if i == len(keys)-1 {
+			return value
+		}
+		if nextMap, ok := value.(map[string]interface{}); ok {
+			current = nextMap
+		} else {
+			slog.Debug("Key path is not a map", "path", keys[:i+1], "key", key)
+			return nil
+		}
+	}
+	return nil
+}
+
+// TruncateTitle shortens post text for display.
+func TruncateTitle(title string) string {
+	if len(title) > 64 {
+		return title[:61] + "..."
+	}
+	return title
+}
@@ -0,0 +1,113 @@
+package bsky
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Short links on this host redirect to the full post URL.
+const shortLinkHost = "go.bsky.app"
+
+var recordKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_~.:-]{1,512}$`)
+
+// ExtractPostDetails returns the profile (handle or DID) and record key of the post the input points to.
+// It accepts post URLs from any client that uses the /profile/X/post/Y scheme, at:// URIs,
+// go.bsky.app short links, and bare "<handle or DID>/<rkey>" pairs.
+func (c *Client) ExtractPostDetails(ctx context.Context, input string) (string, string, error) {
+	profile, rkey, err := c.parsePostInput(ctx, strings.TrimSpace(input))
+	if err != nil {
+		return "", "", err
+	}
+
+	profile = strings.TrimPrefix(profile, "@")
+	if !isValidActor(profile) {
+		return "", "", fmt.Errorf("invalid handle or DID: %s", profile)
+	}
+
+	// Post rkeys are normally TIDs, but any valid record key is accepted
+	if !recordKeyRegex.MatchString(rkey) || rkey == "." || rkey == ".." {
+		return "", "", fmt.Errorf("invalid record key: %s", rkey)
+	}
+
+	return profile, rkey, nil
+}
+
+func (c *Client) parsePostInput(ctx context.Context, input string) (string, string, error) {
+	if input == "" {
+		return "", "", fmt.Errorf("invalid URL format")
+	}
+
+	// at://<authority>/app.bsky.feed.post/<rkey>
+	if rest, ok := strings.CutPrefix(input, "at://"); ok {
+		parts := strings.Split(rest, "/")
+		if len(parts) != 3 || parts[1] != "app.bsky.feed.post" {
+			return "", "", fmt.Errorf("AT-URI does not point to a post: %s", input)
+		}
+		return parts[0], parts[2], nil
+	}
+
+	// "<handle or DID> <rkey>"
+	if fields := strings.Fields(input); len(fields) == 2 {
+		return fields[0], fields[1], nil
+	}
+
+	// Links are often pasted without a scheme
+	if !strings.Contains(input, "://") && (strings.HasPrefix(input, shortLinkHost+"/") || strings.Contains(input, "/profile/")) {
+		input = "https://" + input
+	}
+
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		u, err := url.Parse(input)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid URL format: %v", err)
+		}
+
+		if strings.EqualFold(u.Hostname(), shortLinkHost) {
+			u, err = c.resolveShortLink(ctx, u.String())
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		// Query strings and fragments are ignored, only the path matters
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(segments) == 4 && segments[0] == "profile" && segments[2] == "post" {
+			return segments[1], segments[3], nil
+		}
+		return "", "", fmt.Errorf("URL does not point to a post: %s", input)
+	}
+
+	// "<handle or DID>/<rkey>" or "<handle or DID>/post/<rkey>"
+	segments := strings.Split(strings.Trim(input, "/"), "/")
+	switch {
+	case len(segments) == 2:
+		return segments[0], segments[1], nil
+	case len(segments) == 3 && segments[1] == "post":
+		return segments[0], segments[2], nil
+	}
+
+	return "", "", fmt.Errorf("invalid URL format")
+}
+
+// resolveShortLink follows the redirects of a short link and returns the final URL.
+func (c *Client) resolveShortLink(ctx context.Context, link string) (*url.URL, error) {
+	slog.DebugContext(ctx, "Resolving short link", "url", link)
+
+	resp, err := c.get(ctx, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve short link: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("short link returned status code: %d", resp.StatusCode)
+	}
+
+	slog.DebugContext(ctx, "Short link resolved", "url", resp.Request.URL.String())
+	return resp.Request.URL, nil
+}
@@ -0,0 +1,241 @@
+package bsky
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Refresh the access token this long before it expires.
+const tokenRefreshMargin = time.Minute
+
+// XRPCClient sends AppView queries. Without credentials it talks to the AppView directly,
+// with an app password it creates a session and proxies queries through the account's PDS.
+type XRPCClient struct {
+	HTTPClient  *http.Client
+	AppViewURL  string // Used for unauthenticated queries
+	AppViewDID  string // Service the PDS proxies authenticated queries to
+	PDSURL      string // Where the session is created
+	Identifier  string // Handle or email of the account
+	AppPassword string
+
+	mu      sync.Mutex
+	session *xrpcSession
+}
+
+type xrpcSession struct {
+	DID        string `json:"did"`
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+	expires    time.Time
+}
+
+// XRPCError is an error response from an XRPC service. Name is the error the
+// service reported, e.g. "NotFound" or "ExpiredToken", and empty when the body
+// wasn't an XRPC error, in which case Message holds the body.
+type XRPCError struct {
+	Status  int
+	Name    string
+	Message string
+}
+
+func (e *XRPCError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("API returned status: %v, response: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("API returned status: %v, error: %s, message: %s", e.Status, e.Name, e.Message)
+}
+
+// NewXRPCClient returns an unauthenticated client for the public Bluesky AppView.
+func NewXRPCClient() *XRPCClient {
+	return &XRPCClient{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		AppViewURL: "https://public.api.bsky.app",
+		AppViewDID: "did:web:api.bsky.app#bsky_appview",
+		PDSURL:     "https://bsky.social",
+	}
+}
+
+// Authenticated reports whether queries go through an authenticated session.
+func (c *XRPCClient) Authenticated() bool {
+	return c.Identifier != "" && c.AppPassword != ""
+}
+
+// Health checks that the service queries are sent to answers /xrpc/_health: the PDS
+// when authenticated, the AppView otherwise.
+func (c *XRPCClient) Health(ctx context.Context) error {
+	base := c.AppViewURL
+	if c.Authenticated() {
+		base = c.PDSURL
+	}
+	_, err := c.do(ctx, http.MethodGet, base+"/xrpc/_health", nil, nil)
+	return err
+}
+
+// Query runs an XRPC query and returns the response body.
+func (c *XRPCClient) Query(ctx context.Context, nsid string, params url.Values) ([]byte, error) {
+	if !c.Authenticated() {
+		return c.do(ctx, http.MethodGet, fmt.Sprintf("%s/xrpc/%s?%s", c.AppViewURL, nsid, params.Encode()), nil, nil)
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		headers := map[string]string{
+			"Authorization": "Bearer " + token,
+			"atproto-proxy": c.AppViewDID,
+		}
+		body, err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/xrpc/%s?%s", c.PDSURL, nsid, params.Encode()), nil, headers)
+
+		// The token can expire early, e.g. when the session is revoked; try again with a new one
+		var xerr *XRPCError
+		if attempt == 0 && errors.As(err, &xerr) && xerr.Name == "ExpiredToken" {
+			c.mu.Lock()
+			c.session.expires = time.Time{}
+			c.mu.Unlock()
+			continue
+		}
+		return body, err
+	}
+}
+
+// accessToken returns a valid access token, creating or refreshing the session as needed.
+func (c *XRPCClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil && time.Until(c.session.expires) > tokenRefreshMargin {
+		return c.session.AccessJwt, nil
+	}
+
+	if c.session != nil {
+		session, err := c.refreshSession(ctx, c.session.RefreshJwt)
+		if err == nil {
+			c.session = session
+			return session.AccessJwt, nil
+		}
+		slog.WarnContext(ctx, "Failed to refresh session, creating a new one", "error", err)
+	}
+
+	session, err := c.createSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.session = session
+	return session.AccessJwt, nil
+}
+
+func (c *XRPCClient) createSession(ctx context.Context) (*xrpcSession, error) {
+	slog.InfoContext(ctx, "Creating session", "identifier", c.Identifier)
+
+	payload, err := json.Marshal(map[string]string{
+		"identifier": c.Identifier,
+		"password":   c.AppPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.do(ctx, http.MethodPost, c.PDSURL+"/xrpc/com.atproto.server.createSession", payload, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return parseSession(body)
+}
+
+func (c *XRPCClient) refreshSession(ctx context.Context, refreshJwt string) (*xrpcSession, error) {
+	slog.DebugContext(ctx, "Refreshing session", "identifier", c.Identifier)
+
+	headers := map[string]string{"Authorization": "Bearer " + refreshJwt}
+	body, err := c.do(ctx, http.MethodPost, c.PDSURL+"/xrpc/com.atproto.server.refreshSession", nil, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	return parseSession(body)
+}
+
+func parseSession(body []byte) (*xrpcSession, error) {
+	var session xrpcSession
+	if err := json.Unmarshal(body, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %v", err)
+	}
+	if session.AccessJwt == "" {
+		return nil, fmt.Errorf("session response has no access token")
+	}
+
+	session.expires = jwtExpiry(session.AccessJwt)
+	return &session, nil
+}
+
+// jwtExpiry reads the exp claim of a JWT without verifying it. Tokens without one
+// are assumed to be short-lived.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err == nil {
+			var claims struct {
+				Exp int64 `json:"exp"`
+			}
+			if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+				return time.Unix(claims.Exp, 0)
+			}
+		}
+	}
+	return time.Now().Add(5 * time.Minute)
+}
+
+func (c *XRPCClient) do(ctx context.Context, method, apiURL string, payload []byte, headers map[string]string) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var xerr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &xerr) == nil && xerr.Error != "" {
+			return nil, &XRPCError{Status: resp.StatusCode, Name: xerr.Error, Message: xerr.Message}
+		}
+		return nil, &XRPCError{Status: resp.StatusCode, Message: string(body)}
+	}
+
+	return body, nil
+}
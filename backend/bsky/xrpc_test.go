@@ -0,0 +1,231 @@
+package bsky
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePDS stands in for a PDS that proxies queries to the AppView. It issues numbered
+// tokens so tests can tell sessions apart.
+type fakePDS struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	created   int
+	refreshed int
+	expiresIn time.Duration
+	revoked   map[string]bool // Access tokens answered with ExpiredToken
+	revokeAll bool            // Answer every query with ExpiredToken
+	refuse    bool            // Answer refreshSession with ExpiredToken
+	tokens    int
+}
+
+func newFakePDS(t *testing.T) (*fakePDS, *XRPCClient) {
+	pds := &fakePDS{t: t, expiresIn: time.Hour, revoked: make(map[string]bool)}
+	server := httptest.NewServer(pds)
+	t.Cleanup(server.Close)
+
+	client := NewXRPCClient()
+	client.PDSURL = server.URL
+	client.AppViewURL = "http://appview.invalid"
+	client.Identifier = "alice.test"
+	client.AppPassword = "app-password"
+	return pds, client
+}
+
+// revoke makes the PDS reject a token the client still considers valid.
+func (p *fakePDS) revoke(bearer string, refuseRefresh bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revoked[bearer] = true
+	p.refuse = refuseRefresh
+}
+
+// sessions returns how many sessions were created and refreshed.
+func (p *fakePDS) sessions() (created, refreshed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.created, p.refreshed
+}
+
+func (p *fakePDS) token(kind string) string {
+	p.tokens++
+	claims, _ := json.Marshal(map[string]interface{}{"exp": time.Now().Add(p.expiresIn).Unix()})
+	return fmt.Sprintf("header.%s.%s-%d", base64.RawURLEncoding.EncodeToString(claims), kind, p.tokens)
+}
+
+func (p *fakePDS) session() map[string]string {
+	return map[string]string{"did": "did:plc:alice", "accessJwt": p.token("access"), "refreshJwt": p.token("refresh")}
+}
+
+func (p *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	bearer := r.Header.Get("Authorization")
+
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.createSession":
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["identifier"] != "alice.test" || login["password"] != "app-password" {
+			reply(http.StatusUnauthorized, map[string]string{"error": "AuthenticationRequired", "message": "Invalid identifier or password"})
+			return
+		}
+		p.created++
+		reply(http.StatusOK, p.session())
+	case "/xrpc/com.atproto.server.refreshSession":
+		if p.refuse {
+			reply(http.StatusBadRequest, map[string]string{"error": "ExpiredToken", "message": "Token has expired"})
+			return
+		}
+		p.refreshed++
+		reply(http.StatusOK, p.session())
+	case "/xrpc/app.bsky.feed.getPostThread":
+		if r.Header.Get("atproto-proxy") != "did:web:api.bsky.app#bsky_appview" {
+			p.t.Errorf("atproto-proxy header = %q", r.Header.Get("atproto-proxy"))
+		}
+		if p.revokeAll || p.revoked[bearer] {
+			reply(http.StatusBadRequest, map[string]string{"error": "ExpiredToken", "message": "Token has been revoked"})
+			return
+		}
+		reply(http.StatusOK, map[string]string{"token": bearer})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func query(t *testing.T, client *XRPCClient) string {
+	t.Helper()
+	body, err := client.Query(context.Background(), "app.bsky.feed.getPostThread", url.Values{"uri": {"at://did:plc:alice/app.bsky.feed.post/1"}})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var response map[string]string
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return response["token"]
+}
+
+func TestQueryCreatesSessionOnce(t *testing.T) {
+	pds, client := newFakePDS(t)
+
+	first := query(t, client)
+	second := query(t, client)
+	if first != "Bearer "+client.session.AccessJwt || first != second {
+		t.Errorf("queries sent %q and %q, want the session's access token", first, second)
+	}
+	if created, refreshed := pds.sessions(); created != 1 || refreshed != 0 {
+		t.Errorf("created %d and refreshed %d sessions, want 1 and 0", created, refreshed)
+	}
+}
+
+func TestQueryRefreshesSessionBeforeExpiry(t *testing.T) {
+	pds, client := newFakePDS(t)
+	pds.expiresIn = 30 * time.Second // Within tokenRefreshMargin
+
+	first := query(t, client)
+	second := query(t, client)
+	if first == second {
+		t.Errorf("second query reused token %q", first)
+	}
+	if created, refreshed := pds.sessions(); created != 1 || refreshed != 1 {
+		t.Errorf("created %d and refreshed %d sessions, want 1 and 1", created, refreshed)
+	}
+}
+
+func TestQueryRetriesExpiredToken(t *testing.T) {
+	pds, client := newFakePDS(t)
+
+	revoked := query(t, client)
+	pds.revoke(revoked, false)
+
+	if token := query(t, client); token == revoked {
+		t.Errorf("retry used the revoked token %q", token)
+	}
+	if created, refreshed := pds.sessions(); created != 1 || refreshed != 1 {
+		t.Errorf("created %d and refreshed %d sessions, want 1 and 1", created, refreshed)
+	}
+}
+
+func TestQueryCreatesSessionWhenRefreshFails(t *testing.T) {
+	pds, client := newFakePDS(t)
+
+	revoked := query(t, client)
+	pds.revoke(revoked, true)
+
+	if token := query(t, client); token == revoked {
+		t.Errorf("retry used the revoked token %q", token)
+	}
+	if created, refreshed := pds.sessions(); created != 2 || refreshed != 0 {
+		t.Errorf("created %d and refreshed %d sessions, want 2 and 0", created, refreshed)
+	}
+}
+
+func TestQueryGivesUpAfterOneRetry(t *testing.T) {
+	pds, client := newFakePDS(t)
+	pds.revokeAll = true
+
+	_, err := client.Query(context.Background(), "app.bsky.feed.getPostThread", nil)
+	var xerr *XRPCError
+	if !errors.As(err, &xerr) || xerr.Name != "ExpiredToken" || xerr.Status != http.StatusBadRequest {
+		t.Fatalf("Query error = %v, want an ExpiredToken XRPCError", err)
+	}
+	if created, refreshed := pds.sessions(); created != 1 || refreshed != 1 {
+		t.Errorf("created %d and refreshed %d sessions, want 1 and 1", created, refreshed)
+	}
+}
+
+func TestQueryReportsLoginFailure(t *testing.T) {
+	_, client := newFakePDS(t)
+	client.AppPassword = "wrong"
+
+	_, err := client.Query(context.Background(), "app.bsky.feed.getPostThread", nil)
+	var xerr *XRPCError
+	if !errors.As(err, &xerr) || xerr.Name != "AuthenticationRequired" {
+		t.Fatalf("Query error = %v, want an AuthenticationRequired XRPCError", err)
+	}
+}
+
+func TestQueryUnauthenticated(t *testing.T) {
+	appView := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("atproto-proxy") != "" {
+			t.Errorf("unauthenticated query sent auth headers")
+		}
+		if r.URL.Query().Get("uri") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"Params must have the property \"uri\""}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer appView.Close()
+
+	client := NewXRPCClient()
+	client.AppViewURL = appView.URL
+
+	if _, err := client.Query(context.Background(), "app.bsky.feed.getPostThread", url.Values{"uri": {"at://x"}}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	_, err := client.Query(context.Background(), "app.bsky.feed.getPostThread", nil)
+	var xerr *XRPCError
+	if !errors.As(err, &xerr) || xerr.Name != "InvalidRequest" || xerr.Status != http.StatusBadRequest {
+		t.Fatalf("Query error = %v, want an InvalidRequest XRPCError", err)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/apikeys"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+type BulkRequest struct {
+	Profile      string `json:"profile"`
+	Resolution   string `json:"resolution"`   // Falls back to the highest available resolution
+	Format       string `json:"format"`       // "mp4", "ts" or "mkv"
+	Since        string `json:"since"`        // Only posts created at or after this date
+	Until        string `json:"until"`        // Only posts created before this date
+	MaxCount     int    `json:"maxCount"`     // Stop after this many posts, 0 for limits.max_bulk_posts
+	SkipExisting bool   `json:"skipExisting"` // Skip posts already in the local archive
+
+	AcknowledgeLabels bool `json:"acknowledgeLabels"` // Required for posts the label policy flags
+}
+
+// bulk starts a background job that downloads every video a profile has posted.
+func bulk(w http.ResponseWriter, r *http.Request) {
+	var input BulkRequest
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		jsonError(w, errInvalidRequest, "Invalid request")
+		slog.WarnContext(r.Context(), "Error decoding request body", "error", err)
+		return
+	}
+
+	if input.Profile == "" {
+		jsonError(w, errInvalidRequest, "Profile is required")
+		return
+	}
+
+	// Archive under the DID so handle changes don't split a profile's videos
+	input.Profile, err = dl.ResolveProfile(r.Context(), input.Profile)
+	if err != nil {
+		downloadError(w, r, err)
+		return
+	}
+
+	if input.Format == "" {
+		input.Format = "mp4"
+	}
+	if !transcode.VideoFormats[input.Format] {
+		jsonError(w, errUnsupportedFormat, "Invalid format. Only 'mp4', 'ts' and 'mkv' are supported.")
+		return
+	}
+
+	since, err := parseDate(input.Since)
+	if err != nil {
+		jsonError(w, errInvalidRequest, "Invalid since date")
+		return
+	}
+	until, err := parseDate(input.Until)
+	if err != nil {
+		jsonError(w, errInvalidRequest, "Invalid until date")
+		return
+	}
+
+	if input.MaxCount < 0 {
+		jsonError(w, errInvalidRequest, "maxCount must not be negative")
+		return
+	}
+	if input.MaxCount > cfg.Limits.MaxBulkPosts {
+		jsonError(w, errInvalidRequest, fmt.Sprintf("maxCount must not exceed %d", cfg.Limits.MaxBulkPosts))
+		return
+	}
+	// Leaving maxCount out mustn't queue up a whole feed
+	if input.MaxCount == 0 {
+		input.MaxCount = cfg.Limits.MaxBulkPosts
+	}
+
+	if !checkKeyQuota(w, r, input.Format, "mp4") {
+		return
+	}
+
+	if jobs.Stopping() {
+		jsonError(w, errShuttingDown, "Server is shutting down, please try again later")
+		return
+	}
+
+	// Bulk jobs outlive the request that started them, so they run on the job's own context
+	job, ctx := jobs.Create(r.Context(), "bulk", jobOwner(r))
+	slog.InfoContext(ctx, "Created bulk job", "profile", input.Profile)
+
+	key := requestKey(r)
+	saved := savedJob{Client: clientID(r), Request: input}
+	if key != nil {
+		saved.KeyID = key.ID
+	}
+	jobs.SetResume(job.ID, saved)
+	jobs.Run(func() { runBulkJob(ctx, job.ID, saved.Client, key, input, since, until) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"jobID":  job.ID,
+		"status": string(JobQueued),
+	})
+}
+
+// archiveURL returns the link to a post bulk jobs archived, served under /archive/.
+func archiveURL(profile, name string) string {
+	return fmt.Sprintf("%s/archive/%s/%s", strings.TrimSuffix(cfg.Server.BaseURL, "/"), profile, name)
+}
+
+// parseDate accepts either a full RFC 3339 timestamp or a plain YYYY-MM-DD date.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// runBulkJob downloads every post of a bulk job in turn. Downloads count against key, if set.
+// A job resumed after a restart skips the posts it had already finished.
+func runBulkJob(ctx context.Context, jobID, client string, key *apikeys.Key, input BulkRequest, since, until time.Time) {
+	jobs.Update(jobID, func(job *Job) {
+		if job.Status == JobQueued {
+			job.Status = JobRunning
+		}
+	})
+
+	videos, err := dl.AuthorVideos(ctx, input.Profile, since, until, input.MaxCount)
+	if err != nil && ctx.Err() != nil {
+		stopItems(ctx, jobID, 0)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Bulk job failed", "error", err)
+		jobs.Finish(jobID, JobFailed, err)
+		return
+	}
+
+	// Enqueue every post before starting so progress can be reported, keeping the
+	// outcome of posts a resumed job had already finished
+	finished := make([]bool, len(videos))
+	jobs.Update(jobID, func(job *Job) {
+		previous := make(map[string]JobItem)
+		for _, item := range job.Items {
+			if item.Status == JobCompleted || item.Status == JobFailed {
+				previous[item.PostID] = item
+			}
+		}
+
+		job.Items = nil
+		job.Total = len(videos)
+		job.Completed, job.Skipped, job.Failed = 0, 0, 0
+		for i, video := range videos {
+			item, ok := previous[video.PostID]
+			switch {
+			case !ok:
+				item = JobItem{Profile: video.Profile, PostID: video.PostID, Status: JobQueued}
+			case item.Skipped:
+				job.Skipped++
+			case item.Status == JobCompleted:
+				job.Completed++
+			default:
+				job.Failed++
+			}
+			finished[i] = ok
+			job.Items = append(job.Items, item)
+		}
+	})
+
+	for i, video := range videos {
+		if finished[i] {
+			continue
+		}
+		if ctx.Err() != nil || jobs.Stopping() {
+			stopItems(ctx, jobID, i)
+			return
+		}
+
+		// The archive is kept out of the work dir, so the cleanup task doesn't touch it
+		archiveName := fmt.Sprintf("%s_linuxlock.org.%s", video.PostID, input.Format)
+		archivePath := filepath.Join(cfg.Storage.ArchiveDir, video.Profile, archiveName)
+
+		if input.SkipExisting {
+			if _, err := os.Stat(archivePath); err == nil {
+				slog.InfoContext(ctx, "Skipping archived post", "postID", video.PostID)
+				jobs.Update(jobID, func(job *Job) {
+					job.Items[i].Status = JobCompleted
+					job.Items[i].Skipped = true
+					job.Items[i].Filename = archiveURL(video.Profile, archiveName)
+					job.Skipped++
+				})
+				continue
+			}
+		}
+
+		if err := video.Moderation.Check(input.AcknowledgeLabels); err != nil {
+			slog.WarnContext(ctx, "Label policy blocked post", "postID", video.PostID, "error", err)
+			jobs.Update(jobID, func(job *Job) {
+				job.Items[i].fail(&downloader.LabelError{Decision: video.Moderation})
+				job.Failed++
+			})
+			continue
+		}
+
+		if key != nil {
+			if err := apiKeys.CheckBytes(key); err != nil {
+				jobs.Update(jobID, func(job *Job) {
+					job.Items[i].Status = JobFailed
+					job.Items[i].Error = "Daily download quota exceeded"
+					job.Items[i].ErrorCode = errQuotaExceeded.name
+					job.Failed++
+				})
+				continue
+			}
+		}
+
+		// Every post takes its turn in the download queue like a single download would
+		ticket, err := waitTurn(ctx, jobID, client)
+		if err != nil {
+			stopItems(ctx, jobID, i)
+			return
+		}
+
+		jobs.Update(jobID, func(job *Job) { job.Items[i].Status = JobRunning })
+
+		result, err := archiveVideo(ctx, video, input, archivePath)
+		ticket.Done()
+		if ctx.Err() != nil {
+			// The downloader has already removed the partial files
+			stopItems(ctx, jobID, i)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to download post", "postID", video.PostID, "error", err)
+			jobs.Update(jobID, func(job *Job) {
+				job.Items[i].fail(err)
+				job.Failed++
+			})
+			continue
+		}
+
+		recordUsage(key, archivePath)
+
+		jobs.Update(jobID, func(job *Job) {
+			job.Items[i].Status = JobCompleted
+			job.Items[i].Filename = archiveURL(video.Profile, archiveName)
+			job.Items[i].CID = result.VerifiedCID
+			job.Completed++
+		})
+	}
+
+	jobs.Finish(jobID, JobCompleted, nil)
+	slog.InfoContext(ctx, "Bulk job finished")
+}
+
+// waitTurn queues the job's next post and waits until it may run, keeping the
+// ticket in the job store meanwhile so the job status can report its queue position.
+func waitTurn(ctx context.Context, jobID, client string) (*scheduler.Ticket, error) {
+	ticket, err := queue.SubmitWait(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs.SetTicket(jobID, ticket)
+	defer jobs.SetTicket(jobID, nil)
+
+	if err := ticket.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// stopItems ends a job that was cancelled or told to stop before item index from.
+// While the server shuts down the remaining items are put back in the queue, so the
+// job is saved and resumes after the restart; otherwise they are marked cancelled.
+func stopItems(ctx context.Context, jobID string, from int) {
+	job, _ := jobs.Get(jobID)
+	if !jobs.Stopping() || job.Status == JobCancelled {
+		cancelRemaining(jobID, from)
+		slog.InfoContext(ctx, "Job cancelled")
+		return
+	}
+
+	jobs.Update(jobID, func(job *Job) {
+		job.Status = JobQueued
+		for i := from; i < len(job.Items); i++ {
+			if !job.Items[i].Status.Finished() {
+				job.Items[i].Status = JobQueued
+			}
+		}
+	})
+	slog.InfoContext(ctx, "Job stopped for shutdown, it resumes after the restart")
+}
+
+// cancelRemaining marks every item from index on that hasn't finished as cancelled.
+func cancelRemaining(jobID string, from int) {
+	jobs.Update(jobID, func(job *Job) {
+		for i := from; i < len(job.Items); i++ {
+			if !job.Items[i].Status.Finished() {
+				job.Items[i].Status = JobCancelled
+			}
+		}
+	})
+}
+
+// archiveVideo runs a single post through the download pipeline and moves the result into the archive.
+func archiveVideo(ctx context.Context, video downloader.FeedVideo, input BulkRequest, archivePath string) (*downloader.Result, error) {
+	result, err := dl.Download(ctx, downloader.Request{
+		Profile:           video.Profile,
+		PostID:            video.PostID,
+		Details:           video.Details,
+		Resolution:        input.Resolution,
+		Fallback:          true,
+		Format:            input.Format,
+		AcknowledgeLabels: input.AcknowledgeLabels,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.MoveFile(result.FilePath, archivePath); err != nil {
+		return nil, fmt.Errorf("failed to move video into archive: %v", err)
+	}
+	if err := storage.MoveFile(storage.SidecarPath(result.FilePath), storage.SidecarPath(archivePath)); err != nil {
+		return nil, fmt.Errorf("failed to move sidecar into archive: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Archived video", "path", archivePath)
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Rudra644/bluesky_downloader/downloader"
+)
+
+// captionsHandler downloads a single caption track as a standalone .vtt or .srt file.
+func captionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Profile string `json:"profile"`
+		PostID  string `json:"postID"`
+		Lang    string `json:"lang"`
+		Format  string `json:"format"` // "vtt" or "srt"
+
+		AcknowledgeLabels bool `json:"acknowledgeLabels"` // Required for posts the label policy flags
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		jsonError(w, errInvalidRequest, "Invalid request")
+		slog.WarnContext(r.Context(), "Error decoding request body", "error", err)
+		return
+	}
+
+	if input.Profile == "" || input.PostID == "" || input.Lang == "" {
+		jsonError(w, errInvalidRequest, "Profile, PostID, and Lang are required")
+		return
+	}
+
+	if !checkKeyQuota(w, r, input.Format, "vtt") {
+		return
+	}
+
+	result, err := dl.DownloadCaption(r.Context(), downloader.CaptionRequest{
+		Profile: input.Profile,
+		PostID:  input.PostID,
+		Lang:    input.Lang,
+		Format:  input.Format,
+
+		AcknowledgeLabels: input.AcknowledgeLabels,
+	})
+	if err != nil {
+		downloadError(w, r, err)
+		return
+	}
+
+	recordDownload(r, result)
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"message":  "Captions processed successfully",
+		"filename": fileURL(result),
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
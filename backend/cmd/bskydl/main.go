@@ -0,0 +1,356 @@
+// Command bskydl downloads Bluesky videos from the command line using the same
+// pipeline as the HTTP server.
+//
+//	bskydl [flags] <post URL>...
+//	bskydl [flags] -input urls.txt
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+	"github.com/Rudra644/bluesky_downloader/logging"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+// Exit codes
+const (
+	exitOK      = 0 // Every post was downloaded (or listed)
+	exitFailed  = 1 // At least one post failed
+	exitUsage   = 2 // Invalid flags or arguments
+	exitPartial = 3 // Some posts failed and some succeeded
+
+	exitInterrupted = 130 // Stopped with Ctrl-C
+)
+
+const defaultOutput = "{handle}_{postID}.{format}"
+
+type options struct {
+	resolution   string
+	format       string
+	output       string
+	captions     []string
+	listFormats  bool
+	jsonOutput   bool
+	acknowledge  bool
+	writeInfo    bool
+	showProgress bool
+}
+
+// Result is printed for every post, as a JSON line with -json.
+type Result struct {
+	URL         string   `json:"url"`
+	Status      string   `json:"status"` // "ok" or "error"
+	Profile     string   `json:"profile,omitempty"`
+	Handle      string   `json:"handle,omitempty"`
+	PostID      string   `json:"postID,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	File        string   `json:"file,omitempty"`
+	Resolution  string   `json:"resolution,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	CID         string   `json:"cid,omitempty"`
+	Resolutions []string `json:"resolutions,omitempty"`
+	Formats     []string `json:"formats,omitempty"`
+	Captions    []string `json:"captions,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("bskydl", flag.ContinueOnError)
+	resolution := flags.String("resolution", "", "Resolution to download, e.g. 720p or original (default: highest available)")
+	format := flags.String("format", "mp4", "Output format: mp4, ts or mkv")
+	output := flags.String("output", defaultOutput, "Output file template. Placeholders: {handle} {did} {postID} {resolution} {format} {date}")
+	input := flags.String("input", "", "Read post URLs from a file, one per line (- for stdin)")
+	captions := flags.String("captions", "", "Comma-separated caption languages to mux as soft subtitles (mp4 and mkv only)")
+	listFormats := flags.Bool("list-formats", false, "List the available resolutions, formats and captions without downloading")
+	jsonOutput := flags.Bool("json", false, "Print one JSON object per post instead of text")
+	acknowledge := flags.Bool("acknowledge-labels", false, "Download posts the label policy requires acknowledgement for")
+	writeInfo := flags.Bool("write-info-json", false, "Keep the .info.json metadata sidecar next to each file")
+	noProgress := flags.Bool("no-progress", false, "Don't show the progress bar")
+	verbose := flags.Bool("verbose", false, "Print pipeline debug logs to stderr")
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bskydl [flags] <post URL>...")
+		fmt.Fprintln(flags.Output(), "       bskydl [flags] -input urls.txt")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Exit codes: 0 success, 1 failure, 2 usage error, 3 some posts failed, 130 interrupted")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	urls := flags.Args()
+	if *input != "" {
+		fileURLs, err := readURLs(*input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bskydl:", err)
+			return exitUsage
+		}
+		urls = append(urls, fileURLs...)
+	}
+	if len(urls) == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if !transcode.VideoFormats[*format] {
+		fmt.Fprintf(os.Stderr, "bskydl: invalid format %q, only mp4, ts and mkv are supported\n", *format)
+		return exitUsage
+	}
+
+	opts := options{
+		resolution:   *resolution,
+		format:       *format,
+		output:       *output,
+		listFormats:  *listFormats,
+		jsonOutput:   *jsonOutput,
+		acknowledge:  *acknowledge,
+		writeInfo:    *writeInfo,
+		showProgress: !*noProgress && !*jsonOutput && isTerminal(os.Stderr),
+	}
+	if *captions != "" {
+		opts.captions = strings.Split(*captions, ",")
+		if opts.format == "ts" {
+			fmt.Fprintln(os.Stderr, "bskydl: captions can only be muxed into mp4 or mkv")
+			return exitUsage
+		}
+	}
+
+	// Pipeline logs go to stderr, stdout is reserved for results
+	logOutput := io.Discard
+	if *verbose {
+		logOutput = os.Stderr
+	}
+	logger, err := logging.New(logOutput, "debug", "text")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bskydl:", err)
+		return exitFailed
+	}
+	slog.SetDefault(logger)
+
+	// Work in a scratch directory so intermediate files don't end up next to the output
+	workDir, err := os.MkdirTemp("", "bskydl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bskydl:", err)
+		return exitFailed
+	}
+	defer os.RemoveAll(workDir)
+	dl := downloader.New(downloader.WithWorkDir(workDir))
+
+	// Ctrl-C stops the current download, kills ffmpeg and lets the scratch directory be removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	failed := 0
+	for _, postURL := range urls {
+		result := processURL(ctx, dl, postURL, opts)
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "bskydl: interrupted")
+			return exitInterrupted
+		}
+		if result.Status != "ok" {
+			failed++
+		}
+		printResult(os.Stdout, result, opts)
+	}
+
+	switch {
+	case failed == 0:
+		return exitOK
+	case failed == len(urls):
+		return exitFailed
+	default:
+		return exitPartial
+	}
+}
+
+// processURL downloads a single post, or lists its formats with -list-formats.
+func processURL(ctx context.Context, dl *downloader.Downloader, postURL string, opts options) Result {
+	result := Result{URL: postURL, Status: "error"}
+
+	info, err := dl.Inspect(ctx, postURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Profile = info.Profile
+	result.PostID = info.PostID
+	result.Handle = info.Handle
+	result.Title = bsky.TruncateTitle(info.Details.Title)
+
+	if info.Details.MediaType != "video" {
+		result.Error = downloader.ErrNoVideo.Error()
+		return result
+	}
+
+	if info.Moderation.Action != bsky.LabelAllow {
+		result.Warnings = info.Moderation.Reasons
+	}
+
+	if opts.listFormats {
+		result.Status = "ok"
+		result.Resolutions = info.Resolutions
+		result.Formats = []string{"mp4", "ts", "mkv"}
+		result.Captions = info.Captions
+		return result
+	}
+
+	req := downloader.Request{
+		Profile:           info.Profile,
+		PostID:            info.PostID,
+		Details:           info.Details,
+		Resolution:        opts.resolution,
+		Format:            opts.format,
+		Captions:          opts.captions,
+		AcknowledgeLabels: opts.acknowledge,
+	}
+	if opts.showProgress {
+		bar := newProgressBar(os.Stderr, info.PostID)
+		defer bar.Done()
+		req.Progress = bar.Update
+	}
+
+	videoResult, err := dl.Download(ctx, req)
+	if errors.Is(err, downloader.ErrResolutionUnavailable) {
+		result.Error = fmt.Sprintf("%v (available: %s)", err, strings.Join(info.Resolutions, ", "))
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer os.RemoveAll(filepath.Dir(videoResult.FilePath))
+
+	// Move the file out of the scratch directory
+	outputPath, err := filepath.Abs(renderOutput(opts.output, info.Details, info.Profile, info.PostID, videoResult.Source, opts.format))
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid output path: %v", err)
+		return result
+	}
+	if err := storage.MoveFile(videoResult.FilePath, outputPath); err != nil {
+		result.Error = fmt.Sprintf("failed to save video: %v", err)
+		return result
+	}
+	if opts.writeInfo {
+		if err := storage.MoveFile(storage.SidecarPath(videoResult.FilePath), storage.SidecarPath(outputPath)); err != nil {
+			result.Error = fmt.Sprintf("failed to save info.json: %v", err)
+			return result
+		}
+	}
+
+	result.Status = "ok"
+	result.File = outputPath
+	result.Resolution = videoResult.Source
+	result.Format = opts.format
+	result.CID = videoResult.VerifiedCID
+	return result
+}
+
+func printResult(w io.Writer, result Result, opts options) {
+	if opts.jsonOutput {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if result.Status != "ok" {
+		fmt.Fprintf(os.Stderr, "bskydl: %s: %s\n", result.URL, result.Error)
+		return
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "bskydl: warning: %s\n", warning)
+	}
+
+	if opts.listFormats {
+		fmt.Fprintf(w, "%s/%s: %s\n", result.Handle, result.PostID, result.Title)
+		fmt.Fprintf(w, "  resolutions: %s\n", strings.Join(result.Resolutions, " "))
+		fmt.Fprintf(w, "  formats:     %s\n", strings.Join(result.Formats, " "))
+		if len(result.Captions) > 0 {
+			fmt.Fprintf(w, "  captions:    %s\n", strings.Join(result.Captions, " "))
+		}
+		return
+	}
+	fmt.Fprintln(w, result.File)
+}
+
+// renderOutput fills in the placeholders of an output template.
+func renderOutput(template string, details *bsky.PostDetails, profile, postID, resolution, format string) string {
+	date := ""
+	if len(details.CreatedAt) >= 10 {
+		date = details.CreatedAt[:10]
+	}
+	handle := details.Handle
+	if handle == "" || handle == "handle.invalid" {
+		handle = profile
+	}
+
+	replacer := strings.NewReplacer(
+		"{handle}", sanitize(handle),
+		"{did}", sanitize(profile),
+		"{postID}", sanitize(postID),
+		"{resolution}", sanitize(resolution),
+		"{format}", format,
+		"{date}", date,
+	)
+	return replacer.Replace(template)
+}
+
+// sanitize keeps placeholder values from adding directories or characters some filesystems reject.
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func readURLs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %v", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input file: %v", err)
+	}
+	return urls, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
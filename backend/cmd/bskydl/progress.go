@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const progressWidth = 30
+
+// progressBar draws a single-line bar that is redrawn in place.
+type progressBar struct {
+	w     io.Writer
+	label string
+	drawn bool
+}
+
+func newProgressBar(w io.Writer, label string) *progressBar {
+	return &progressBar{w: w, label: label}
+}
+
+// Update matches downloader.ProgressFunc.
+func (b *progressBar) Update(stage string, done, total int) {
+	if total <= 0 {
+		return
+	}
+
+	filled := progressWidth * done / total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+	status := fmt.Sprintf("%d/%d", done, total)
+	if stage == "transcode" {
+		status = "converting"
+		if done == total {
+			status = "done"
+		}
+	}
+
+	fmt.Fprintf(b.w, "\r%s [%s] %3d%% %-9s %s", b.label, bar, 100*done/total, stage, status)
+	b.drawn = true
+}
+
+// Done clears the bar so the next output starts on a clean line.
+func (b *progressBar) Done() {
+	if b.drawn {
+		fmt.Fprintf(b.w, "\r%s\r", strings.Repeat(" ", len(b.label)+progressWidth+30))
+	}
+}
@@ -0,0 +1,457 @@
+// Package config loads the server configuration. Every setting has a default, which
+// a YAML or TOML file, then environment variables and then command-line flags override:
+//
+//	bluesky-downloader -config config.yaml -addr :8080
+//
+// Each field's env and flag tags name the variable and flag that set it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the server.
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Storage   Storage   `yaml:"storage" toml:"storage"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	Bluesky   Bluesky   `yaml:"bluesky" toml:"bluesky"`
+	Limits    Limits    `yaml:"limits" toml:"limits"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	APIKeys   APIKeys   `yaml:"api_keys" toml:"api_keys"`
+	Health    Health    `yaml:"health" toml:"health"`
+}
+
+// Server configures the HTTP listener and the URLs handed out to clients.
+type Server struct {
+	Addr           string   `yaml:"addr" toml:"addr" env:"LISTEN_ADDR" flag:"addr" help:"address to listen on"`
+	BaseURL        string   `yaml:"base_url" toml:"base_url" env:"PUBLIC_BASE_URL" flag:"base-url" help:"URL clients reach the server at, used for download links"`
+	CORSOrigins    []string `yaml:"cors_origins" toml:"cors_origins" env:"CORS_ORIGINS" flag:"cors-origins" help:"comma-separated origins allowed to call the API from a browser"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" help:"comma-separated IPs and CIDR ranges whose forwarding headers are believed"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" help:"how long running downloads may finish after SIGTERM before they are cancelled"`
+}
+
+// Storage configures where downloads are kept and for how long.
+type Storage struct {
+	WorkDir         string        `yaml:"work_dir" toml:"work_dir" env:"WORK_DIR" flag:"work-dir" help:"directory downloads are processed and stored in"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" toml:"cleanup_interval" env:"CLEANUP_INTERVAL" flag:"cleanup-interval" help:"how often expired downloads are removed"`
+	MaxAge          time.Duration `yaml:"max_age" toml:"max_age" env:"FILE_MAX_AGE" flag:"file-max-age" help:"how long downloads are kept"`
+	JobsFile        string        `yaml:"jobs_file" toml:"jobs_file" env:"JOBS_FILE" flag:"jobs-file" help:"file unfinished background jobs are saved to on shutdown and resumed from, empty to drop them"`
+	ArchiveDir      string        `yaml:"archive_dir" toml:"archive_dir" env:"ARCHIVE_DIR" flag:"archive-dir" help:"directory bulk jobs archive posts in, outside work_dir so the cleanup leaves it alone; served publicly under /archive/"`
+	JobTTL          time.Duration `yaml:"job_ttl" toml:"job_ttl" env:"JOB_TTL" flag:"job-ttl" help:"how long finished jobs can still be looked up"`
+}
+
+// Log configures log/slog.
+type Log struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL" flag:"log-level" help:"debug, info, warn or error"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" flag:"log-format" help:"text or json"`
+}
+
+// Tracing configures the OpenTelemetry exporter.
+type Tracing struct {
+	Endpoint    string `yaml:"endpoint" toml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"otlp-endpoint" help:"OTLP/HTTP collector to export spans to, tracing is off when empty"`
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME" flag:"service-name" help:"service name spans are reported under"`
+}
+
+// Bluesky configures the services posts are fetched from.
+type Bluesky struct {
+	AppViewURL         string   `yaml:"appview_url" toml:"appview_url" env:"BSKY_APPVIEW_URL" flag:"appview-url" help:"AppView to query instead of the public one"`
+	AppViewDID         string   `yaml:"appview_did" toml:"appview_did" env:"BSKY_APPVIEW_DID" flag:"appview-did" help:"DID of the AppView authenticated requests are proxied to"`
+	PDSURL             string   `yaml:"pds_url" toml:"pds_url" env:"BSKY_PDS_URL" flag:"pds-url" help:"PDS to sign in with"`
+	Identifier         string   `yaml:"identifier" toml:"identifier" env:"BSKY_IDENTIFIER" flag:"identifier" help:"handle or DID to sign in as"`
+	AppPassword        string   `yaml:"app_password" toml:"app_password" env:"BSKY_APP_PASSWORD" flag:"app-password" secret:"true" help:"app password to sign in with"`
+	LabelPolicyFile    string   `yaml:"label_policy_file" toml:"label_policy_file" env:"LABEL_POLICY_FILE" flag:"label-policy" help:"JSON file overriding the label policy"`
+	ExternalMediaHosts []string `yaml:"external_media_hosts" toml:"external_media_hosts" env:"EXTERNAL_MEDIA_HOSTS" flag:"external-media-hosts" help:"comma-separated hosts external media may be fetched from"`
+}
+
+// Authenticated reports whether an app password session is configured.
+func (b Bluesky) Authenticated() bool {
+	return b.Identifier != "" && b.AppPassword != ""
+}
+
+// Limits caps how much work runs at once and what a single download may cost.
+type Limits struct {
+	MaxActiveDownloads   int `yaml:"max_active_downloads" toml:"max_active_downloads" env:"MAX_ACTIVE_DOWNLOADS" flag:"max-active-downloads" help:"downloads running at once"`
+	MaxQueuedDownloads   int `yaml:"max_queued_downloads" toml:"max_queued_downloads" env:"MAX_QUEUED_DOWNLOADS" flag:"max-queued-downloads" help:"downloads waiting for a slot before clients are turned away"`
+	QueueAsyncAfter      int `yaml:"queue_async_after" toml:"queue_async_after" env:"QUEUE_ASYNC_AFTER" flag:"queue-async-after" help:"queue position past which a download is answered with 202 and a job to poll, 0 to always wait"`
+	NetworkConcurrency   int `yaml:"network_concurrency" toml:"network_concurrency" env:"NETWORK_CONCURRENCY" flag:"network-concurrency" help:"segment fetches running at once"`
+	TranscodeConcurrency int `yaml:"transcode_concurrency" toml:"transcode_concurrency" env:"TRANSCODE_CONCURRENCY" flag:"transcode-concurrency" help:"ffmpeg processes running at once"`
+	MaxVideoSeconds      int `yaml:"max_video_seconds" toml:"max_video_seconds" env:"MAX_VIDEO_SECONDS" flag:"max-video-seconds" help:"longest video or thread that may be downloaded, 0 for no limit"`
+	MaxOutputMB          int `yaml:"max_output_mb" toml:"max_output_mb" env:"MAX_OUTPUT_MB" flag:"max-output-mb" help:"largest file a download may produce, 0 for no limit"`
+	MaxBlobMB            int `yaml:"max_blob_mb" toml:"max_blob_mb" env:"MAX_BLOB_MB" flag:"max-blob-mb" help:"largest original upload fetched from a PDS, larger ones come from HLS; 0 for no limit"`
+	MaxBulkPosts         int `yaml:"max_bulk_posts" toml:"max_bulk_posts" env:"MAX_BULK_POSTS" flag:"max-bulk-posts" help:"most posts a bulk job downloads, also when it doesn't set maxCount"`
+}
+
+// RateLimit configures the per-IP rate limits.
+type RateLimit struct {
+	ProcessPerMinute  int `yaml:"process_per_minute" toml:"process_per_minute" env:"PROCESS_RATE_PER_MINUTE" flag:"process-rate" help:"/process requests per minute per client"`
+	ProcessBurst      int `yaml:"process_burst" toml:"process_burst" env:"PROCESS_BURST" flag:"process-burst" help:"/process requests a client may make at once"`
+	DownloadPerMinute int `yaml:"download_per_minute" toml:"download_per_minute" env:"DOWNLOAD_RATE_PER_MINUTE" flag:"download-rate" help:"download requests per minute per client"`
+	DownloadBurst     int `yaml:"download_burst" toml:"download_burst" env:"DOWNLOAD_BURST" flag:"download-burst" help:"download requests a client may make at once"`
+}
+
+// APIKeys configures partner API keys.
+type APIKeys struct {
+	File      string `yaml:"file" toml:"file" env:"API_KEYS_FILE" flag:"api-keys" help:"JSON file of API keys, keys and the /admin endpoints are disabled when empty"`
+	UsageFile string `yaml:"usage_file" toml:"usage_file" env:"API_KEYS_USAGE_FILE" flag:"api-keys-usage" help:"file daily usage is flushed to every 30s and on shutdown, defaults to the keys file with .usage appended"`
+	Required  bool   `yaml:"required" toml:"required" env:"API_KEYS_REQUIRED" flag:"api-keys-required" help:"turn away requests without an API key"`
+}
+
+// Health configures the readiness checks of /readyz.
+type Health struct {
+	MinFreeMB    int           `yaml:"min_free_mb" toml:"min_free_mb" env:"READY_MIN_FREE_MB" flag:"ready-min-free-mb" help:"free space the work directory needs to be ready"`
+	CheckAppView bool          `yaml:"check_appview" toml:"check_appview" env:"READY_CHECK_APPVIEW" flag:"ready-check-appview" help:"also require the AppView to be reachable"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"READY_TIMEOUT" flag:"ready-timeout" help:"how long the readiness checks may take"`
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:    ":4000",
+			BaseURL: "http://localhost:4000",
+			CORSOrigins: []string{
+				"http://localhost:3000",
+				"http://linuxlock.org",
+				"https://linuxlock.org",
+			},
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Storage: Storage{
+			WorkDir:         "videos",
+			CleanupInterval: 15 * time.Minute,
+			MaxAge:          30 * time.Minute,
+			JobsFile:        "jobs.json",
+			ArchiveDir:      "archive",
+			JobTTL:          24 * time.Hour,
+		},
+		Log:     Log{Level: "info", Format: "text"},
+		Tracing: Tracing{ServiceName: "bluesky-downloader"},
+		Limits: Limits{
+			MaxActiveDownloads:   4,
+			MaxQueuedDownloads:   32,
+			QueueAsyncAfter:      4,
+			NetworkConcurrency:   16,
+			TranscodeConcurrency: runtime.NumCPU(),
+			MaxVideoSeconds:      600,
+			MaxOutputMB:          500,
+			MaxBlobMB:            500,
+			MaxBulkPosts:         200,
+		},
+		RateLimit: RateLimit{
+			ProcessPerMinute:  30,
+			ProcessBurst:      10,
+			DownloadPerMinute: 6,
+			DownloadBurst:     3,
+		},
+		Health: Health{
+			MinFreeMB: 1024,
+			Timeout:   5 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the file named by -config or
+// CONFIG_FILE, the environment and the command-line arguments, in increasing order
+// of precedence, and validates the result.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("bluesky-downloader", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	var set []*fieldFlag
+	for _, f := range fields(cfg) {
+		if f.flag == "" {
+			continue
+		}
+		ff := &fieldFlag{field: f}
+		set = append(set, ff)
+		fs.Var(ff, f.flag, f.help)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Empty variables count as unset
+	for _, f := range fields(cfg) {
+		if value := os.Getenv(f.env); f.env != "" && value != "" {
+			if err := setField(f.value, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", f.env, err)
+			}
+		}
+	}
+
+	// Flags were parsed first to find the file, but are applied last
+	for _, ff := range set {
+		if ff.raw == nil {
+			continue
+		}
+		if err := setField(ff.field.value, *ff.raw); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %v", ff.field.flag, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML (.yaml, .yml) or TOML (.toml) file over cfg, rejecting unknown keys.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown setting %s in %s", undecoded[0], path)
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate reports every setting that is out of range, joined into one error.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	base, err := url.Parse(c.Server.BaseURL)
+	check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "",
+		"server.base_url must be an absolute http(s) URL, got %q", c.Server.BaseURL)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		// An origin is a scheme and host, with an optional port but no path
+		u, err := url.Parse(origin)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "" && u.RawQuery == "",
+			"server.cors_origins must be http(s) origins like https://example.com, got %q", origin)
+	}
+
+	check(c.Storage.WorkDir != "", "storage.work_dir is required")
+	check(c.Storage.CleanupInterval > 0, "storage.cleanup_interval must be positive")
+	check(c.Storage.MaxAge > 0, "storage.max_age must be positive")
+	check(c.Storage.ArchiveDir != "", "storage.archive_dir is required")
+	check(c.Storage.JobTTL > 0, "storage.job_ttl must be positive")
+	if c.Storage.WorkDir != "" && c.Storage.ArchiveDir != "" {
+		workDir, _ := filepath.Abs(c.Storage.WorkDir)
+		archiveDir, _ := filepath.Abs(c.Storage.ArchiveDir)
+		rel, err := filepath.Rel(workDir, archiveDir)
+		inside := err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+		check(!inside, "storage.archive_dir must not be inside storage.work_dir, where the cleanup would remove it")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		check(false, "log.format must be text or json, got %q", c.Log.Format)
+	}
+
+	for name, value := range map[string]string{
+		"tracing.endpoint":    c.Tracing.Endpoint,
+		"bluesky.appview_url": c.Bluesky.AppViewURL,
+		"bluesky.pds_url":     c.Bluesky.PDSURL,
+	} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		check(err == nil && u.Scheme != "" && u.Host != "", "%s must be an absolute URL, got %q", name, value)
+	}
+	check((c.Bluesky.Identifier == "") == (c.Bluesky.AppPassword == ""),
+		"bluesky.identifier and bluesky.app_password must be set together")
+
+	check(c.Limits.MaxActiveDownloads > 0, "limits.max_active_downloads must be positive")
+	check(c.Limits.MaxQueuedDownloads >= 0, "limits.max_queued_downloads must not be negative")
+	check(c.Limits.QueueAsyncAfter >= 0, "limits.queue_async_after must not be negative")
+	check(c.Limits.NetworkConcurrency > 0, "limits.network_concurrency must be positive")
+	check(c.Limits.TranscodeConcurrency > 0, "limits.transcode_concurrency must be positive")
+	check(c.Limits.MaxVideoSeconds >= 0, "limits.max_video_seconds must not be negative")
+	check(c.Limits.MaxOutputMB >= 0, "limits.max_output_mb must not be negative")
+	check(c.Limits.MaxBlobMB >= 0, "limits.max_blob_mb must not be negative")
+	check(c.Limits.MaxBulkPosts > 0, "limits.max_bulk_posts must be positive")
+
+	check(c.RateLimit.ProcessPerMinute > 0 && c.RateLimit.ProcessBurst > 0, "rate_limit.process_per_minute and process_burst must be positive")
+	check(c.RateLimit.DownloadPerMinute > 0 && c.RateLimit.DownloadBurst > 0, "rate_limit.download_per_minute and download_burst must be positive")
+
+	check(c.APIKeys.File != "" || !c.APIKeys.Required, "api_keys.required needs api_keys.file")
+
+	check(c.Health.MinFreeMB >= 0, "health.min_free_mb must not be negative")
+	check(c.Health.Timeout > 0, "health.timeout must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns the configuration as nested maps keyed like the YAML file, with
+// durations as strings and secrets replaced, ready to be encoded as JSON.
+func (c *Config) Redacted() map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, f := range fields(c) {
+		section, _ := out[f.section].(map[string]interface{})
+		if section == nil {
+			section = map[string]interface{}{}
+			out[f.section] = section
+		}
+
+		var value interface{} = f.value.Interface()
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			if f.secret && v != "" {
+				value = "[redacted]"
+			}
+		}
+		section[f.key] = value
+	}
+	return out
+}
+
+// field is a single setting of a Config section.
+type field struct {
+	section, key string // Names in the config file
+	env, flag    string
+	help         string
+	secret       bool
+	value        reflect.Value
+}
+
+// fields lists the settings of cfg, section by section.
+func fields(cfg *Config) []field {
+	var list []field
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := root.Type().Field(i).Tag.Get("yaml")
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+			list = append(list, field{
+				section: sectionName,
+				key:     tag.Get("yaml"),
+				env:     tag.Get("env"),
+				flag:    tag.Get("flag"),
+				help:    tag.Get("help"),
+				secret:  tag.Get("secret") == "true",
+				value:   section.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+// setField parses value into a setting. Lists are comma-separated.
+func setField(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 15m", value)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				list = append(list, entry)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldFlag remembers the value a flag was given until it is applied.
+type fieldFlag struct {
+	field field
+	raw   *string
+}
+
+func (f *fieldFlag) String() string {
+	if f == nil || f.raw == nil {
+		return ""
+	}
+	return *f.raw
+}
+
+func (f *fieldFlag) Set(value string) error {
+	// Reject bad values while parsing, so the error names the flag
+	probe := reflect.New(f.field.value.Type()).Elem()
+	if err := setField(probe, value); err != nil {
+		return err
+	}
+	f.raw = &value
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be passed as a bare -flag.
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStorageAndCORS(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    string // Substring of the error, empty if the config is valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"archive beside work dir", func(c *Config) { c.Storage.WorkDir, c.Storage.ArchiveDir = "data/videos", "data/archive" }, ""},
+		{"archive named like work dir", func(c *Config) { c.Storage.WorkDir, c.Storage.ArchiveDir = "videos", "videos-archive" }, ""},
+		{"no archive dir", func(c *Config) { c.Storage.ArchiveDir = "" }, "storage.archive_dir is required"},
+		{"archive inside work dir", func(c *Config) { c.Storage.WorkDir, c.Storage.ArchiveDir = "videos", "videos/archive" }, "must not be inside storage.work_dir"},
+		{"archive is work dir", func(c *Config) { c.Storage.WorkDir, c.Storage.ArchiveDir = "videos", "./videos" }, "must not be inside storage.work_dir"},
+		{"archive inside absolute work dir", func(c *Config) { c.Storage.WorkDir, c.Storage.ArchiveDir = "/srv/videos", "/srv/videos/archive" }, "must not be inside storage.work_dir"},
+
+		{"any origin", func(c *Config) { c.Server.CORSOrigins = []string{"*"} }, ""},
+		{"origin with port", func(c *Config) { c.Server.CORSOrigins = []string{"http://localhost:5173", "https://example.com"} }, ""},
+		{"origin with path", func(c *Config) { c.Server.CORSOrigins = []string{"https://example.com/app"} }, "server.cors_origins"},
+		{"origin without scheme", func(c *Config) { c.Server.CORSOrigins = []string{"example.com"} }, "server.cors_origins"},
+		{"origin with other scheme", func(c *Config) { c.Server.CORSOrigins = []string{"ftp://example.com"} }, "server.cors_origins"},
+		{"origin with query", func(c *Config) { c.Server.CORSOrigins = []string{"https://example.com?x=1"} }, "server.cors_origins"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.modify(c)
+			err := c.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
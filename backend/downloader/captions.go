@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/hls"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+// CaptionRequest describes a caption track to download as a standalone file.
+type CaptionRequest struct {
+	Profile string
+	PostID  string
+	Lang    string
+	Format  string // "vtt" or "srt", defaults to vtt
+
+	AcknowledgeLabels bool // Required for posts the label policy flags
+}
+
+// DownloadCaption saves a single caption track of a video post as WebVTT or SubRip.
+func (d *Downloader) DownloadCaption(ctx context.Context, req CaptionRequest) (*Result, error) {
+	if req.Lang == "" {
+		return nil, fmt.Errorf("%w: lang is required", ErrInvalidRequest)
+	}
+	if req.Format == "" {
+		req.Format = "vtt"
+	}
+	if req.Format != "vtt" && req.Format != "srt" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, req.Format)
+	}
+
+	profile, details, err := d.fetchPost(ctx, req.Profile, req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	moderation, err := d.checkLabels(details, req.AcknowledgeLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	caption := findCaption(d.availableCaptions(ctx, details), req.Lang)
+	if caption == nil {
+		return nil, fmt.Errorf("%w for language: %s", ErrCaptionsUnavailable, req.Lang)
+	}
+
+	postDir, err := d.store.PostDir(req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	vttPath := filepath.Join(postDir, fmt.Sprintf("%s_%s.vtt", req.PostID, caption.Lang))
+	if err := d.fetchCaption(ctx, profile, *caption, vttPath); err != nil {
+		return nil, upstreamError(err)
+	}
+
+	finalFileName := fmt.Sprintf("%s_%s_linuxlock.org.%s", req.PostID, caption.Lang, req.Format)
+	finalFilePath := filepath.Join(postDir, finalFileName)
+
+	vtt, err := os.ReadFile(vttPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read captions: %v", err)
+	}
+	output := string(vtt)
+	if req.Format == "srt" {
+		output = hls.VTTToSRT(output)
+	}
+	if err := os.WriteFile(finalFilePath, []byte(output), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write captions: %v", err)
+	}
+
+	return &Result{
+		FileName: finalFileName,
+		FilePath: finalFilePath,
+		RelPath:  d.store.RelPath(finalFilePath),
+		Source:   caption.Source,
+		Warnings: warnings(moderation),
+		Details:  details,
+	}, nil
+}
+
+// availableCaptions merges the record and playlist captions, preferring the record's blob for each language.
+func (d *Downloader) availableCaptions(ctx context.Context, details *bsky.PostDetails) []bsky.Caption {
+	captions := append([]bsky.Caption(nil), details.Captions...)
+
+	renditions, err := d.hls.Subtitles(ctx, details.Playlist)
+	if err != nil {
+		slog.WarnContext(ctx, "Error fetching playlist captions", "error", err)
+		return captions
+	}
+
+	for _, rendition := range renditions {
+		if findCaption(captions, rendition.Lang) == nil {
+			captions = append(captions, bsky.Caption{Lang: rendition.Lang, Source: "hls", URI: rendition.URI})
+		}
+	}
+	return captions
+}
+
+func findCaption(captions []bsky.Caption, lang string) *bsky.Caption {
+	for i := range captions {
+		if strings.EqualFold(captions[i].Lang, lang) {
+			return &captions[i]
+		}
+	}
+	return nil
+}
+
+// fetchCaption saves a caption track as WebVTT.
+func (d *Downloader) fetchCaption(ctx context.Context, profile string, caption bsky.Caption, outputPath string) error {
+	if caption.Source == "record" {
+		return d.bsky.FetchBlob(ctx, profile, caption.Cid, outputPath)
+	}
+	return d.hls.DownloadSubtitles(ctx, caption.URI, outputPath)
+}
+
+// prepareSubtitles downloads the requested caption languages into dir for muxing.
+func (d *Downloader) prepareSubtitles(ctx context.Context, details *bsky.PostDetails, profile, postID, dir string, langs []string) ([]transcode.Subtitle, error) {
+	if len(langs) == 0 {
+		return nil, nil
+	}
+	captions := d.availableCaptions(ctx, details)
+
+	var subtitles []transcode.Subtitle
+	for _, lang := range langs {
+		caption := findCaption(captions, lang)
+		if caption == nil {
+			removeSubtitles(subtitles)
+			return nil, fmt.Errorf("%w for language: %s", ErrCaptionsUnavailable, lang)
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.vtt", postID, caption.Lang))
+		if err := d.fetchCaption(ctx, profile, *caption, path); err != nil {
+			removeSubtitles(subtitles)
+			return nil, upstreamError(err)
+		}
+		subtitles = append(subtitles, transcode.Subtitle{Lang: caption.Lang, Path: path})
+	}
+	return subtitles, nil
+}
+
+// removeSubtitles deletes caption files fetched for a download that didn't complete.
+func removeSubtitles(subtitles []transcode.Subtitle) {
+	for _, subtitle := range subtitles {
+		os.Remove(subtitle.Path)
+	}
+}
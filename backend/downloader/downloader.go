@@ -0,0 +1,323 @@
+// Package downloader fetches Bluesky posts and runs their media through the download
+// pipeline shared by the HTTP server and the bskydl command.
+//
+//	dl := downloader.New(downloader.WithWorkDir("videos"))
+//	info, err := dl.Inspect(ctx, "https://bsky.app/profile/alice.bsky.social/post/3k...")
+//	result, err := dl.Download(ctx, downloader.Request{Profile: info.Profile, PostID: info.PostID})
+//
+// Cancelling ctx stops network transfers and ffmpeg, and removes the partial files of the download.
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/hls"
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+// Downloader runs posts through the download pipeline. It is safe for concurrent use.
+type Downloader struct {
+	bsky       *bsky.Client
+	hls        *hls.Client
+	transcoder transcode.Transcoder
+	store      *storage.Store
+	policy     *bsky.LabelPolicy
+	cpu        *scheduler.Limiter
+
+	maxDuration   time.Duration // Longest video (or thread, in total) that may be downloaded, 0 for no limit
+	maxOutputSize int64         // Largest file a download may produce in bytes, 0 for no limit
+}
+
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithHTTPClient sends every request (AppView, identity, PDS, CDN and playlists) through the given client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Downloader) {
+		d.bsky.HTTPClient = client
+		d.bsky.XRPC.HTTPClient = client
+		d.bsky.Identity.HTTPClient = client
+		d.hls.HTTPClient = client
+	}
+}
+
+// WithTransport sends every request through the given transport, keeping each client's timeout.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(d *Downloader) {
+		d.bsky.HTTPClient = withTransport(d.bsky.HTTPClient, transport)
+		d.bsky.XRPC.HTTPClient = withTransport(d.bsky.XRPC.HTTPClient, transport)
+		d.bsky.Identity.HTTPClient = withTransport(d.bsky.Identity.HTTPClient, transport)
+		d.hls.HTTPClient = withTransport(d.hls.HTTPClient, transport)
+	}
+}
+
+func withTransport(client *http.Client, transport http.RoundTripper) *http.Client {
+	copied := *client
+	copied.Transport = transport
+	return &copied
+}
+
+// WithAppViewURL points AppView queries and handle resolution at a different service.
+func WithAppViewURL(appViewURL string) Option {
+	return func(d *Downloader) {
+		d.bsky.XRPC.AppViewURL = strings.TrimSuffix(appViewURL, "/")
+		d.bsky.Identity.ResolveHandleURL = d.bsky.XRPC.AppViewURL
+	}
+}
+
+// WithAppViewDID sets the service the PDS proxies authenticated queries to.
+func WithAppViewDID(did string) Option {
+	return func(d *Downloader) {
+		d.bsky.XRPC.AppViewDID = did
+	}
+}
+
+// WithPDSURL sets where authenticated sessions are created.
+func WithPDSURL(pdsURL string) Option {
+	return func(d *Downloader) {
+		d.bsky.XRPC.PDSURL = strings.TrimSuffix(pdsURL, "/")
+	}
+}
+
+// WithAppPassword authenticates AppView queries with an app password.
+func WithAppPassword(identifier, password string) Option {
+	return func(d *Downloader) {
+		d.bsky.XRPC.Identifier = identifier
+		d.bsky.XRPC.AppPassword = password
+	}
+}
+
+// WithWorkDir sets the directory downloads are processed and stored in. Defaults to "videos".
+func WithWorkDir(dir string) Option {
+	return func(d *Downloader) {
+		d.store = storage.New(dir)
+	}
+}
+
+// WithConcurrency sets how many HLS segments are downloaded in parallel.
+func WithConcurrency(n int) Option {
+	return func(d *Downloader) {
+		d.hls.Concurrency = n
+	}
+}
+
+// WithNetworkLimiter caps HLS segment fetches across every download sharing the limiter.
+func WithNetworkLimiter(limiter *scheduler.Limiter) Option {
+	return func(d *Downloader) {
+		d.hls.Limiter = limiter
+	}
+}
+
+// WithCPULimiter caps transcodes across every download sharing the limiter.
+func WithCPULimiter(limiter *scheduler.Limiter) Option {
+	return func(d *Downloader) {
+		d.cpu = limiter
+	}
+}
+
+// WithMaxDuration refuses videos, or threads in total, that run longer than max.
+func WithMaxDuration(max time.Duration) Option {
+	return func(d *Downloader) {
+		d.maxDuration = max
+	}
+}
+
+// WithMaxOutputSize fails downloads whose output file is larger than max bytes.
+func WithMaxOutputSize(max int64) Option {
+	return func(d *Downloader) {
+		d.maxOutputSize = max
+	}
+}
+
+// WithMaxBlobSize stops fetching original uploads larger than max bytes, which are
+// then downloaded from the HLS playlist instead.
+func WithMaxBlobSize(max int64) Option {
+	return func(d *Downloader) {
+		d.bsky.MaxBlobSize = max
+	}
+}
+
+// WithTranscoder replaces the ffmpeg transcoder.
+func WithTranscoder(t transcode.Transcoder) Option {
+	return func(d *Downloader) {
+		d.transcoder = t
+	}
+}
+
+// WithLabelPolicy replaces the default label policy.
+func WithLabelPolicy(policy *bsky.LabelPolicy) Option {
+	return func(d *Downloader) {
+		d.policy = policy
+	}
+}
+
+// WithExternalMediaHosts replaces the hosts external media may be fetched from.
+func WithExternalMediaHosts(hosts []string) Option {
+	return func(d *Downloader) {
+		d.bsky.ExternalMediaHosts = make(map[string]bool)
+		for _, host := range hosts {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				d.bsky.ExternalMediaHosts[host] = true
+			}
+		}
+	}
+}
+
+// New returns a downloader for the public Bluesky services that stores files under "videos".
+func New(opts ...Option) *Downloader {
+	d := &Downloader{
+		bsky:       bsky.NewClient(),
+		hls:        hls.NewClient(),
+		transcoder: transcode.NewFFmpeg(),
+		store:      storage.New("videos"),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.policy == nil {
+		d.policy = bsky.DefaultLabelPolicy(d.bsky.XRPC.Authenticated())
+	}
+	d.transcoder = transcode.Limit(d.transcoder, d.cpu)
+	return d
+}
+
+// WorkDir returns the directory downloads are stored in.
+func (d *Downloader) WorkDir() string {
+	return d.store.Root
+}
+
+// Authenticated reports whether AppView queries go through an app password session.
+func (d *Downloader) Authenticated() bool {
+	return d.bsky.XRPC.Authenticated()
+}
+
+// CheckTranscoder verifies that the transcoder's tools are installed, if it can tell.
+func (d *Downloader) CheckTranscoder(ctx context.Context) error {
+	if checker, ok := d.transcoder.(transcode.Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// CheckAppView verifies that the service AppView queries go to is reachable.
+func (d *Downloader) CheckAppView(ctx context.Context) error {
+	return d.bsky.XRPC.Health(ctx)
+}
+
+// ResolveProfile returns the DID for a handle or DID.
+func (d *Downloader) ResolveProfile(ctx context.Context, profile string) (string, error) {
+	did, err := d.bsky.Identity.ResolveIdentifier(ctx, profile)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if errors.Is(err, bsky.ErrInvalidIdentifier) {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		if errors.Is(err, bsky.ErrHandleNotFound) || errors.Is(err, bsky.ErrDIDNotFound) {
+			return "", fmt.Errorf("%w: %s: %v", ErrProfileNotFound, profile, err)
+		}
+		return "", fmt.Errorf("%w: failed to resolve %s: %v", ErrUpstream, profile, err)
+	}
+	return did, nil
+}
+
+// ResolveURL returns the DID of the author and the ID of the post a URL points at.
+func (d *Downloader) ResolveURL(ctx context.Context, postURL string) (string, string, error) {
+	handle, postID, err := d.bsky.ExtractPostDetails(ctx, postURL)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	// Normalize the profile to its DID so links survive handle changes
+	profile, err := d.ResolveProfile(ctx, handle)
+	if err != nil {
+		return "", "", err
+	}
+	return profile, postID, nil
+}
+
+// fetchPost resolves the profile and fetches the post's metadata.
+func (d *Downloader) fetchPost(ctx context.Context, profile, postID string) (string, *bsky.PostDetails, error) {
+	if postID == "" {
+		return "", nil, fmt.Errorf("%w: postID is required", ErrInvalidRequest)
+	}
+
+	did, err := d.ResolveProfile(ctx, profile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	start := time.Now()
+	details, err := d.bsky.FetchPostMetadata(ctx, did, postID)
+	metrics.ObserveStage("metadata", start)
+	if err != nil {
+		return "", nil, upstreamError(err)
+	}
+	return did, details, nil
+}
+
+// checkLabels applies the label policy, returning a *LabelError if the download can't go ahead.
+func (d *Downloader) checkLabels(details *bsky.PostDetails, acknowledged bool) (bsky.LabelDecision, error) {
+	moderation := d.policy.Evaluate(details.Labels, details.AuthorLabels)
+	if moderation.Check(acknowledged) != nil {
+		return moderation, &LabelError{Decision: moderation}
+	}
+	return moderation, nil
+}
+
+// checkDuration returns ErrTooLong if the videos together run longer than allowed.
+func (d *Downloader) checkDuration(ctx context.Context, videos ...*bsky.PostDetails) error {
+	if d.maxDuration <= 0 {
+		return nil
+	}
+
+	var total time.Duration
+	for _, details := range videos {
+		start := time.Now()
+		duration, err := d.hls.Duration(ctx, details.Playlist)
+		metrics.ObserveStage("playlist", start)
+		if err != nil {
+			return fmt.Errorf("%w: failed to determine video duration: %v", ErrUpstream, err)
+		}
+		total += duration
+	}
+	if total > d.maxDuration {
+		return fmt.Errorf("%w: %s exceeds the limit of %s", ErrTooLong, total.Round(time.Second), d.maxDuration)
+	}
+	return nil
+}
+
+// checkSize returns ErrTooLarge if the file at path is bigger than allowed.
+func (d *Downloader) checkSize(path string) error {
+	if d.maxOutputSize <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > d.maxOutputSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, info.Size(), d.maxOutputSize)
+	}
+	return nil
+}
+
+// warnings returns the reasons to show alongside a download the label policy let through.
+func warnings(moderation bsky.LabelDecision) []string {
+	if moderation.Action == bsky.LabelAllow {
+		return nil
+	}
+	return moderation.Reasons
+}
@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/hls"
+)
+
+// Errors returned by the Downloader. Use errors.Is to test for them, as they are usually wrapped.
+var (
+	ErrInvalidRequest        = errors.New("invalid request")
+	ErrInvalidURL            = errors.New("invalid post URL")
+	ErrProfileNotFound       = errors.New("could not resolve profile")
+	ErrPostNotFound          = bsky.ErrPostNotFound
+	ErrNoVideo               = errors.New("post has no video")
+	ErrNoImages              = errors.New("post has no images")
+	ErrNoExternalMedia       = errors.New("post has no external media from an allowed host")
+	ErrUnsupportedFormat     = errors.New("unsupported format")
+	ErrResolutionUnavailable = hls.ErrResolutionNotFound
+	ErrCaptionsUnavailable   = errors.New("captions not available")
+	ErrExternalMediaFetch    = errors.New("failed to fetch external media")
+	ErrBlobVerification      = bsky.ErrBlobVerification
+	ErrTooLong               = errors.New("video is longer than allowed")
+	ErrTooLarge              = errors.New("output is larger than allowed")
+	ErrBlocked               = errors.New("blocked between the author and the account")
+	ErrRejected              = errors.New("request rejected by the AppView")
+	ErrUpstream              = errors.New("upstream service unavailable")
+	ErrTranscode             = errors.New("transcoding failed")
+)
+
+// upstreamError classifies a failure talking to Bluesky or its CDN. Errors the AppView
+// reports about the request itself map to the matching error above, anything else
+// means the service is unavailable and is wrapped in ErrUpstream.
+func upstreamError(err error) error {
+	if err == nil || errors.Is(err, ErrPostNotFound) || errors.Is(err, ErrResolutionUnavailable) ||
+		errors.Is(err, ErrBlobVerification) || errors.Is(err, ErrUpstream) {
+		return err
+	}
+
+	var xerr *bsky.XRPCError
+	if errors.As(err, &xerr) {
+		switch xerr.Name {
+		case "NotFound":
+			return fmt.Errorf("%w: %v", ErrPostNotFound, err)
+		case "BlockedActor", "BlockedByActor":
+			return fmt.Errorf("%w: %v", ErrBlocked, err)
+		case "InvalidRequest":
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrUpstream, err)
+}
+
+// LabelError is returned when the label policy refuses a post or requires acknowledgement.
+type LabelError struct {
+	Decision bsky.LabelDecision
+}
+
+func (e *LabelError) Error() string {
+	return e.Decision.Check(false).Error()
+}
+
+// Refused reports whether the post can't be downloaded even with acknowledgement.
+func (e *LabelError) Refused() bool {
+	return e.Decision.Action == bsky.LabelRefuse
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+// ExternalRequest describes the external media (e.g. a Tenor GIF) of a post to download.
+type ExternalRequest struct {
+	Profile string
+	PostID  string
+	Format  string // One of transcode.AnimationFormats, defaults to mp4
+
+	AcknowledgeLabels bool
+}
+
+// DownloadExternal fetches the media behind an external embed and converts it to mp4, gif or webp.
+func (d *Downloader) DownloadExternal(ctx context.Context, req ExternalRequest) (*Result, error) {
+	if req.Format == "" {
+		req.Format = "mp4"
+	}
+	if !transcode.AnimationFormats[req.Format] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, req.Format)
+	}
+
+	_, details, err := d.fetchPost(ctx, req.Profile, req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.External == nil {
+		return nil, ErrNoExternalMedia
+	}
+
+	moderation, err := d.checkLabels(details, req.AcknowledgeLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	postDir, err := d.store.PostDir(req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcePath, err := d.bsky.FetchExternalMedia(ctx, details.External.URI, filepath.Join(postDir, req.PostID+"_external"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExternalMediaFetch, err)
+	}
+
+	finalFileName := fmt.Sprintf("%s_linuxlock.org.%s", req.PostID, req.Format)
+	finalFilePath := filepath.Join(postDir, finalFileName)
+	start := time.Now()
+	err = d.transcoder.ConvertAnimation(ctx, sourcePath, finalFilePath, req.Format, containerMetadata(details))
+	metrics.ObserveStage("transcode", start)
+	os.Remove(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error converting external media: %v", ErrTranscode, err)
+	}
+	if err := d.checkSize(finalFilePath); err != nil {
+		os.Remove(finalFilePath)
+		return nil, err
+	}
+
+	if err := storage.WriteSidecar(finalFilePath, newVideoInfo(details, details.External.URI, "", req.Format)); err != nil {
+		slog.ErrorContext(ctx, "Error writing sidecar", "error", err)
+	}
+
+	return &Result{
+		FileName: finalFileName,
+		FilePath: finalFilePath,
+		RelPath:  d.store.RelPath(finalFilePath),
+		Source:   details.External.URI,
+		Warnings: warnings(moderation),
+		Details:  details,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+)
+
+type FeedVideo struct {
+	Profile    string
+	PostID     string
+	CreatedAt  time.Time
+	Moderation bsky.LabelDecision
+	Details    *bsky.PostDetails
+}
+
+// AuthorVideos returns the video posts of a profile created in [since, until), newest first.
+// Zero times leave that end open and maxCount 0 means no limit.
+func (d *Downloader) AuthorVideos(ctx context.Context, profile string, since, until time.Time, maxCount int) ([]FeedVideo, error) {
+	profile, err := d.ResolveProfile(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := d.bsky.FetchAuthorVideos(ctx, profile, since, until, maxCount)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	var videos []FeedVideo
+	for _, details := range posts {
+		// FetchAuthorVideos has already skipped posts without a valid timestamp
+		createdAt, _ := time.Parse(time.RFC3339Nano, details.CreatedAt)
+		videos = append(videos, FeedVideo{
+			Profile:    details.AuthorDID,
+			PostID:     details.RecordKey(),
+			CreatedAt:  createdAt,
+			Moderation: d.policy.Evaluate(details.Labels, details.AuthorLabels),
+			Details:    details,
+		})
+	}
+	return videos, nil
+}
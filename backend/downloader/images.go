@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+// ImageRequest describes the images of a post to download.
+type ImageRequest struct {
+	Profile string
+	PostID  string
+	Index   *int   // Zero-based image to download, all images as a zip when nil
+	Format  string // One of transcode.ImageFormats, defaults to original
+
+	AcknowledgeLabels bool
+}
+
+// DownloadImages downloads one image of a post, or all of them as a zip.
+func (d *Downloader) DownloadImages(ctx context.Context, req ImageRequest) (*Result, error) {
+	if req.Format == "" {
+		req.Format = "original"
+	}
+	if _, ok := transcode.ImageFormats[req.Format]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, req.Format)
+	}
+
+	_, details, err := d.fetchPost(ctx, req.Profile, req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(details.Images) == 0 {
+		return nil, ErrNoImages
+	}
+
+	moderation, err := d.checkLabels(details, req.AcknowledgeLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	images := details.Images
+	if req.Index != nil {
+		if *req.Index < 0 || *req.Index >= len(images) {
+			return nil, fmt.Errorf("%w: invalid image index, the post has %d images", ErrInvalidRequest, len(images))
+		}
+		images = images[*req.Index : *req.Index+1]
+	}
+
+	postDir, err := d.store.PostDir(req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for i, image := range images {
+		number := i + 1
+		if req.Index != nil {
+			number = *req.Index + 1
+		}
+
+		filePath, err := d.downloadImage(ctx, image.Fullsize, filepath.Join(postDir, fmt.Sprintf("%s_%d_linuxlock.org", req.PostID, number)), req.Format)
+		if err != nil {
+			removeFiles(files)
+			return nil, fmt.Errorf("failed to download image %d: %w", number, err)
+		}
+		files = append(files, filePath)
+	}
+
+	// The sidecar sits next to the final file and, for a gallery, inside the zip too
+	info := newVideoInfo(details, "fullsize", "", req.Format)
+	finalFilePath := files[0]
+	if len(files) > 1 {
+		finalFilePath = filepath.Join(postDir, fmt.Sprintf("%s_images_linuxlock.org.zip", req.PostID))
+		if err := storage.WriteSidecar(finalFilePath, info); err != nil {
+			slog.ErrorContext(ctx, "Error writing sidecar", "error", err)
+		} else {
+			files = append(files, storage.SidecarPath(finalFilePath))
+		}
+
+		var names []string
+		for _, file := range files {
+			names = append(names, filepath.Base(file))
+		}
+		if err := storage.ZipFiles(files, names, finalFilePath); err != nil {
+			removeFiles(append(files, finalFilePath))
+			return nil, err
+		}
+	} else if err := storage.WriteSidecar(finalFilePath, info); err != nil {
+		slog.ErrorContext(ctx, "Error writing sidecar", "error", err)
+	}
+	if err := d.checkSize(finalFilePath); err != nil {
+		removeFiles(append(files, finalFilePath, storage.SidecarPath(finalFilePath)))
+		return nil, err
+	}
+
+	return &Result{
+		FileName: filepath.Base(finalFilePath),
+		FilePath: finalFilePath,
+		RelPath:  d.store.RelPath(finalFilePath),
+		Source:   req.Format,
+		Warnings: warnings(moderation),
+		Details:  details,
+	}, nil
+}
+
+// downloadImage saves an image from the CDN next to basePath, converting it if requested,
+// and returns the path of the final file.
+func (d *Downloader) downloadImage(ctx context.Context, imageURL, basePath, format string) (string, error) {
+	originalPath, err := d.bsky.FetchImage(ctx, imageURL, basePath)
+	if err != nil {
+		return "", upstreamError(err)
+	}
+
+	targetExt := transcode.ImageFormats[format]
+	if targetExt == "" || targetExt == filepath.Ext(originalPath) {
+		return originalPath, nil
+	}
+
+	convertedPath := basePath + targetExt
+	start := time.Now()
+	err = d.transcoder.ConvertImage(ctx, originalPath, convertedPath, format)
+	metrics.ObserveStage("transcode", start)
+	if err != nil {
+		os.Remove(originalPath)
+		return "", fmt.Errorf("%w: %v", ErrTranscode, err)
+	}
+	os.Remove(originalPath)
+	return convertedPath, nil
+}
+
+// removeFiles deletes the files of a download that didn't complete.
+func removeFiles(files []string) {
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/metrics"
+)
+
+// OriginalResolution selects the uploaded video blob instead of an HLS rendition.
+const OriginalResolution = "original"
+
+// Inspection describes a post and the downloads it offers.
+type Inspection struct {
+	Profile     string // DID of the author
+	Handle      string
+	PostID      string
+	URI         string
+	Details     *bsky.PostDetails
+	Resolutions []string // Video posts only, lowest first with "original" last when the blob is available
+	Captions    []string // Caption languages of video posts
+	Moderation  bsky.LabelDecision
+}
+
+// Inspect looks up the post a URL points at without downloading anything.
+func (d *Downloader) Inspect(ctx context.Context, postURL string) (*Inspection, error) {
+	profile, postID, err := d.ResolveURL(ctx, postURL)
+	if err != nil {
+		return nil, err
+	}
+	return d.InspectPost(ctx, profile, postID)
+}
+
+// InspectPost looks up a post by profile (handle or DID) and post ID.
+func (d *Downloader) InspectPost(ctx context.Context, profile, postID string) (*Inspection, error) {
+	profile, details, err := d.fetchPost(ctx, profile, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &Inspection{
+		Profile:    profile,
+		Handle:     details.Handle,
+		PostID:     postID,
+		URI:        bsky.PostURI(profile, postID),
+		Details:    details,
+		Moderation: d.policy.Evaluate(details.Labels, details.AuthorLabels),
+	}
+
+	if details.MediaType == "video" {
+		inspection.Resolutions, err = d.resolutions(ctx, details)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch resolutions: %v", ErrUpstream, err)
+		}
+
+		inspection.Captions = []string{}
+		for _, caption := range d.availableCaptions(ctx, details) {
+			inspection.Captions = append(inspection.Captions, caption.Lang)
+		}
+	}
+	return inspection, nil
+}
+
+// resolutions lists the HLS renditions of a video, plus the original upload when its CID is known.
+func (d *Downloader) resolutions(ctx context.Context, details *bsky.PostDetails) ([]string, error) {
+	start := time.Now()
+	resolutions, err := d.hls.Resolutions(ctx, details.Playlist)
+	metrics.ObserveStage("playlist", start)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Cid != "" {
+		resolutions = append(resolutions, OriginalResolution)
+	}
+	return resolutions, nil
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+)
+
+// VideoInfo is written next to every processed file to record where it came from.
+type VideoInfo struct {
+	URI          string                 `json:"uri"`
+	URL          string                 `json:"url"`
+	Author       VideoAuthor            `json:"author"`
+	Text         string                 `json:"text"`
+	Alt          string                 `json:"alt,omitempty"`
+	Images       []bsky.ImageDetails    `json:"images,omitempty"` // Every image of the post, with its alt text
+	CreatedAt    string                 `json:"createdAt"`
+	LikeCount    int                    `json:"likeCount"`
+	ReplyCount   int                    `json:"replyCount"`
+	RepostCount  int                    `json:"repostCount"`
+	QuoteCount   int                    `json:"quoteCount"`
+	Labels       []bsky.Label           `json:"labels,omitempty"`
+	Source       string                 `json:"source"` // The HLS rendition used, "original", "fullsize" for images, or the URL of external media
+	Format       string                 `json:"format"`
+	VerifiedCID  string                 `json:"verifiedCID,omitempty"`
+	DownloadedAt string                 `json:"downloadedAt"`
+	Post         map[string]interface{} `json:"post"` // The full post view as returned by the AppView
+}
+
+type VideoAuthor struct {
+	DID         string `json:"did"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+func newVideoInfo(details *bsky.PostDetails, source, verifiedCID, format string) VideoInfo {
+	return VideoInfo{
+		URI: details.URI,
+		URL: details.URL,
+		Author: VideoAuthor{
+			DID:         details.AuthorDID,
+			Handle:      details.Handle,
+			DisplayName: details.DisplayName,
+		},
+		Text:         details.Title,
+		Alt:          details.Alt,
+		Images:       details.Images,
+		CreatedAt:    details.CreatedAt,
+		LikeCount:    details.LikeCount,
+		ReplyCount:   details.ReplyCount,
+		RepostCount:  details.RepostCount,
+		QuoteCount:   details.QuoteCount,
+		Labels:       append(append([]bsky.Label(nil), details.Labels...), details.AuthorLabels...),
+		Source:       source,
+		Format:       format,
+		VerifiedCID:  verifiedCID,
+		DownloadedAt: time.Now().UTC().Format(time.RFC3339),
+		Post:         details.Post,
+	}
+}
+
+// containerMetadata returns the ffmpeg -metadata entries describing where a video came from.
+func containerMetadata(details *bsky.PostDetails) []string {
+	artist := "@" + details.Handle
+	if details.DisplayName != "" {
+		artist = fmt.Sprintf("%s (@%s)", details.DisplayName, details.Handle)
+	}
+
+	metadata := []string{
+		"title=" + details.Title,
+		"artist=" + artist,
+		"comment=" + strings.TrimSpace(details.URI+" "+details.URL),
+	}
+	if details.CreatedAt != "" {
+		metadata = append(metadata, "creation_time="+details.CreatedAt, "date="+details.CreatedAt)
+	}
+	return metadata
+}
@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/hls"
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+)
+
+type ThreadVideo struct {
+	Profile     string             `json:"profile"`
+	PostID      string             `json:"postID"`
+	Thumbnail   string             `json:"thumbnail"`
+	Title       string             `json:"title"`
+	CreatedAt   string             `json:"createdAt"`
+	Cid         string             `json:"cid"`
+	Resolutions []string           `json:"resolutions"`
+	Moderation  bsky.LabelDecision `json:"moderation"`
+	Details     *bsky.PostDetails  `json:"-"`
+}
+
+// ThreadRequest describes a thread whose videos are downloaded and bundled together.
+type ThreadRequest struct {
+	Profile    string
+	PostID     string
+	Resolution string // Used for every video that has it, the highest resolution otherwise
+	Format     string // One of transcode.VideoFormats, defaults to mp4
+	Bundle     string // "zip" or "concat", defaults to zip
+
+	AcknowledgeLabels bool
+}
+
+// InspectThread returns every video the author of the post posted in its thread, ordered by creation time.
+func (d *Downloader) InspectThread(ctx context.Context, profile, postID string) ([]ThreadVideo, error) {
+	profile, err := d.ResolveProfile(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	posts, err := d.bsky.FetchThreadVideos(ctx, profile, postID)
+	metrics.ObserveStage("metadata", start)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	var videos []ThreadVideo
+	for _, details := range posts {
+		resolutions, err := d.resolutions(ctx, details)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch resolutions: %v", ErrUpstream, err)
+		}
+
+		videos = append(videos, ThreadVideo{
+			Profile:     details.AuthorDID,
+			PostID:      details.RecordKey(),
+			Thumbnail:   details.Thumbnail,
+			Title:       bsky.TruncateTitle(details.Title),
+			CreatedAt:   details.CreatedAt,
+			Cid:         details.Cid,
+			Resolutions: resolutions,
+			Moderation:  d.policy.Evaluate(details.Labels, details.AuthorLabels),
+			Details:     details,
+		})
+	}
+	return videos, nil
+}
+
+// DownloadThread processes every video in the thread and bundles them into a single file.
+func (d *Downloader) DownloadThread(ctx context.Context, req ThreadRequest) (*Result, error) {
+	if req.Format == "" {
+		req.Format = "mp4"
+	}
+	if !transcode.VideoFormats[req.Format] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, req.Format)
+	}
+	if req.Bundle == "" {
+		req.Bundle = "zip"
+	}
+	if req.Bundle != "zip" && req.Bundle != "concat" {
+		return nil, fmt.Errorf("%w: bundle must be zip or concat", ErrInvalidRequest)
+	}
+
+	videos, err := d.InspectThread(ctx, req.Profile, req.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if len(videos) == 0 {
+		return nil, fmt.Errorf("%w: no videos found in thread", ErrNoVideo)
+	}
+
+	// The whole series is refused if any part of it is
+	var warnings []string
+	var posts []*bsky.PostDetails
+	for i, video := range videos {
+		if err := video.Moderation.Check(req.AcknowledgeLabels); err != nil {
+			return nil, fmt.Errorf("video %d: %w", i+1, &LabelError{Decision: video.Moderation})
+		}
+		if video.Moderation.Action != bsky.LabelAllow {
+			warnings = append(warnings, video.Moderation.Reasons...)
+		}
+		posts = append(posts, video.Details)
+	}
+	if err := d.checkDuration(ctx, posts...); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for i, video := range videos {
+		resolution := hls.PickResolution(video.Resolutions, req.Resolution)
+		slog.InfoContext(ctx, "Processing thread video", "index", i+1, "total", len(videos), "postID", video.PostID, "resolution", resolution)
+
+		postDir, err := d.store.PostDir(video.PostID)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := d.processVideo(ctx, Request{PostID: video.PostID, Format: req.Format}, video.Details, video.Profile, postDir, resolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process video %d: %w", i+1, err)
+		}
+		files = append(files, result.FilePath)
+	}
+
+	// Bundle everything into the thread's own directory
+	threadDir, err := d.store.ThreadDir(req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	var finalFileName string
+	if req.Bundle == "concat" {
+		finalFileName = fmt.Sprintf("%s_thread_linuxlock.org.%s", req.PostID, req.Format)
+		start := time.Now()
+		err := d.transcoder.Join(ctx, files, filepath.Join(threadDir, finalFileName), req.Format)
+		metrics.ObserveStage("transcode", start)
+		if err != nil {
+			return nil, fmt.Errorf("%w: error joining videos: %v", ErrTranscode, err)
+		}
+	} else {
+		// Prefix every entry with its position in the series
+		var names []string
+		for i, file := range files {
+			names = append(names, fmt.Sprintf("%02d_%s", i+1, filepath.Base(file)))
+		}
+
+		finalFileName = fmt.Sprintf("%s_thread_linuxlock.org.zip", req.PostID)
+		if err := storage.ZipFiles(files, names, filepath.Join(threadDir, finalFileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	finalFilePath := filepath.Join(threadDir, finalFileName)
+	if err := d.checkSize(finalFilePath); err != nil {
+		os.Remove(finalFilePath)
+		return nil, err
+	}
+	return &Result{
+		FileName: finalFileName,
+		FilePath: finalFilePath,
+		RelPath:  d.store.RelPath(finalFilePath),
+		Source:   req.Bundle,
+		Warnings: warnings,
+	}, nil
+}
@@ -0,0 +1,264 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/hls"
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/tracing"
+	"github.com/Rudra644/bluesky_downloader/transcode"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Request describes a single video to run through the download pipeline.
+type Request struct {
+	Profile    string            // Handle or DID of the author
+	PostID     string            // Record key of the post
+	Details    *bsky.PostDetails // Optional, saves fetching the post again
+	Resolution string            // An HLS rendition such as "720p", "original" for the uploaded blob, or empty for the highest
+	Fallback   bool              // Use the highest resolution when the requested one isn't available
+	Format     string            // One of transcode.VideoFormats, defaults to mp4
+	Captions   []string          // Caption languages to mux as soft subtitles
+
+	AcknowledgeLabels bool         // Required for posts the label policy flags
+	Progress          ProgressFunc // Optional, called as the pipeline advances
+}
+
+// ProgressFunc reports how far a pipeline stage ("download" or "transcode") has got.
+// For downloads total is the number of HLS segments, or 1 for the original blob.
+type ProgressFunc func(stage string, done, total int)
+
+func (req Request) progress(stage string, done, total int) {
+	if req.Progress != nil {
+		req.Progress(stage, done, total)
+	}
+}
+
+// Result describes a file produced by the download pipeline.
+type Result struct {
+	FileName    string
+	FilePath    string
+	RelPath     string // FilePath relative to the work dir, with forward slashes
+	Source      string // The HLS rendition used, "original", or the URL of external media
+	VerifiedCID string // Set when the original blob was verified against its CID
+	Warnings    []string
+	Details     *bsky.PostDetails
+}
+
+// Download runs a video post through the pipeline: download, trim, convert and write its sidecar.
+func (d *Downloader) Download(ctx context.Context, req Request) (*Result, error) {
+	if req.Format == "" {
+		req.Format = "mp4"
+	}
+	if !transcode.VideoFormats[req.Format] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, req.Format)
+	}
+
+	// MPEG TS can't carry soft subtitles
+	if len(req.Captions) > 0 && req.Format == "ts" {
+		return nil, fmt.Errorf("%w: captions can only be muxed into mp4 or mkv", ErrInvalidRequest)
+	}
+
+	details := req.Details
+	profile, err := d.ResolveProfile(ctx, req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		if profile, details, err = d.fetchPost(ctx, profile, req.PostID); err != nil {
+			return nil, err
+		}
+	}
+
+	if details.MediaType != "video" {
+		return nil, ErrNoVideo
+	}
+
+	// Apply the label policy before fetching anything
+	moderation, err := d.checkLabels(details, req.AcknowledgeLabels)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkDuration(ctx, details); err != nil {
+		return nil, err
+	}
+
+	resolutions, err := d.resolutions(ctx, details)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch resolutions: %v", ErrUpstream, err)
+	}
+	resolution := hls.PickResolution(resolutions, req.Resolution)
+	if req.Resolution != "" && resolution != req.Resolution && !req.Fallback {
+		return nil, fmt.Errorf("%w: %s", ErrResolutionUnavailable, req.Resolution)
+	}
+	if resolution == "" {
+		return nil, fmt.Errorf("%w: no resolutions available", ErrResolutionUnavailable)
+	}
+
+	postDir, err := d.store.PostDir(req.PostID)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Processing video", "profile", profile, "postID", req.PostID, "resolution", resolution, "format", req.Format)
+
+	result, err := d.processVideo(ctx, req, details, profile, postDir, resolution)
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = warnings(moderation)
+	return result, nil
+}
+
+// processVideo downloads the requested resolution, trims it and converts it to the desired format.
+// Every stage works in a temporary directory of its own, so concurrent requests for the same post
+// don't share files, and only the finished file and its sidecar are moved into postDir. If any
+// stage fails or ctx is cancelled, the files it produced are removed again.
+func (d *Downloader) processVideo(ctx context.Context, req Request, details *bsky.PostDetails, profile, postDir, resolution string) (_ *Result, err error) {
+	ctx, span := tracing.Start(ctx, "downloader.processVideo",
+		attribute.String("post.id", req.PostID),
+		attribute.String("resolution", resolution),
+		attribute.String("format", req.Format),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	workDir, err := os.MkdirTemp(postDir, ".work-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	defer func() {
+		os.RemoveAll(workDir)
+		if err != nil {
+			// Only succeeds if nothing else lives in the post directory
+			os.Remove(postDir)
+		}
+	}()
+
+	subtitles, err := d.prepareSubtitles(ctx, details, profile, req.PostID, workDir, req.Captions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Paths for processing
+	videoPath := filepath.Join(workDir, fmt.Sprintf("%s.mp4", req.PostID))
+	finalFileName := fmt.Sprintf("%s_linuxlock.org.%s", req.PostID, req.Format)
+	workFilePath := filepath.Join(workDir, finalFileName)
+	finalFilePath := filepath.Join(postDir, finalFileName)
+
+	result := &Result{
+		FileName: finalFileName,
+		FilePath: finalFilePath,
+		RelPath:  d.store.RelPath(finalFilePath),
+		Source:   resolution,
+		Details:  details,
+	}
+
+	// Prefer the original upload when asked, falling back to the best HLS rendition
+	if resolution == OriginalResolution {
+		req.progress("download", 0, 1)
+		err := d.downloadOriginal(ctx, profile, details.Cid, videoPath)
+		switch {
+		case err == nil:
+			result.VerifiedCID = details.Cid
+			req.progress("download", 1, 1)
+		case errors.Is(err, ErrBlobVerification), ctx.Err() != nil:
+			// A blob that doesn't match its CID must not be passed off as the original
+			return nil, err
+		default:
+			slog.WarnContext(ctx, "Original video unavailable, falling back to HLS", "error", err)
+
+			start := time.Now()
+			resolutions, err := d.hls.Resolutions(ctx, details.Playlist)
+			metrics.ObserveStage("playlist", start)
+			if err != nil {
+				return nil, upstreamError(err)
+			}
+			result.Source = hls.PickResolution(resolutions, "")
+			if result.Source == "" {
+				return nil, fmt.Errorf("%w: no resolutions available", ErrResolutionUnavailable)
+			}
+		}
+	}
+
+	if result.VerifiedCID == "" {
+		start := time.Now()
+		segments, err := d.hls.DownloadRendition(ctx, details.Playlist, result.Source, workDir, func(done, total int) {
+			req.progress("download", done, total)
+		})
+		metrics.ObserveStage("segments", start)
+		if err != nil {
+			return nil, upstreamError(err)
+		}
+
+		start = time.Now()
+		err = d.transcoder.CombineSegments(ctx, segments, videoPath)
+		metrics.ObserveStage("combine", start)
+		if err != nil {
+			return nil, fmt.Errorf("%w: error combining segments: %v", ErrTranscode, err)
+		}
+	}
+
+	// Trim the video and convert to the desired format
+	req.progress("transcode", 0, 1)
+	start := time.Now()
+	err = d.transcoder.Convert(ctx, videoPath, workFilePath, transcode.Options{
+		Format:    req.Format,
+		StartTime: "00:00:00.5",
+		Subtitles: subtitles,
+		Metadata:  containerMetadata(details),
+	})
+	metrics.ObserveStage("transcode", start)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error trimming video: %v", ErrTranscode, err)
+	}
+	req.progress("transcode", 1, 1)
+	if err := d.checkSize(workFilePath); err != nil {
+		return nil, err
+	}
+
+	// Record where the file came from
+	err = storage.WriteSidecar(workFilePath, newVideoInfo(details, result.Source, result.VerifiedCID, req.Format))
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the sidecar first, so the file never appears without it
+	if err = os.Rename(storage.SidecarPath(workFilePath), storage.SidecarPath(finalFilePath)); err != nil {
+		return nil, fmt.Errorf("failed to move sidecar into place: %v", err)
+	}
+	if err = os.Rename(workFilePath, finalFilePath); err != nil {
+		os.Remove(storage.SidecarPath(finalFilePath))
+		return nil, fmt.Errorf("failed to move video into place: %v", err)
+	}
+	return result, nil
+}
+
+// downloadOriginal fetches the uploaded video blob from the author's PDS, verifies it against
+// its CID and remuxes it to outputPath. Verification failures wrap ErrBlobVerification.
+func (d *Downloader) downloadOriginal(ctx context.Context, did, cid, outputPath string) error {
+	if cid == "" {
+		return fmt.Errorf("post has no video blob CID")
+	}
+
+	blobPath := outputPath + "_original"
+	defer os.Remove(blobPath)
+
+	start := time.Now()
+	err := d.bsky.FetchBlob(ctx, did, cid, blobPath)
+	metrics.ObserveStage("original", start)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	defer metrics.ObserveStage("combine", start)
+	return d.transcoder.Remux(ctx, blobPath, outputPath)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Rudra644/bluesky_downloader/downloader"
+)
+
+// errorCode identifies a kind of error response. Its name is what clients branch on,
+// retryable tells them whether the same request may succeed later.
+type errorCode struct {
+	name      string
+	status    int
+	retryable bool
+}
+
+// Every error the API responds with.
+var (
+	errInvalidRequest        = errorCode{"invalid_request", http.StatusBadRequest, false}
+	errInvalidURL            = errorCode{"invalid_url", http.StatusBadRequest, false}
+	errUnsupportedFormat     = errorCode{"unsupported_format", http.StatusBadRequest, false}
+	errResolutionUnavailable = errorCode{"resolution_unavailable", http.StatusBadRequest, false}
+	errAPIKeyRequired        = errorCode{"api_key_required", http.StatusUnauthorized, false}
+	errInvalidAPIKey         = errorCode{"invalid_api_key", http.StatusUnauthorized, false}
+	errFormatNotAllowed      = errorCode{"format_not_allowed", http.StatusForbidden, false}
+	errAdminRequired         = errorCode{"admin_required", http.StatusForbidden, false}
+	errLabelRefused          = errorCode{"label_refused", http.StatusForbidden, false}
+	errBlocked               = errorCode{"blocked", http.StatusForbidden, false}
+	errNotFound              = errorCode{"not_found", http.StatusNotFound, false}
+	errProfileNotFound       = errorCode{"profile_not_found", http.StatusNotFound, false}
+	errPostNotFound          = errorCode{"post_not_found", http.StatusNotFound, false}
+	errNoVideo               = errorCode{"no_video", http.StatusNotFound, false}
+	errNoImages              = errorCode{"no_images", http.StatusNotFound, false}
+	errNoExternalMedia       = errorCode{"no_external_media", http.StatusNotFound, false}
+	errCaptionsUnavailable   = errorCode{"captions_unavailable", http.StatusNotFound, false}
+	errJobNotFound           = errorCode{"job_not_found", http.StatusNotFound, false}
+	errKeyNotFound           = errorCode{"key_not_found", http.StatusNotFound, false}
+	errFileNotFound          = errorCode{"file_not_found", http.StatusNotFound, false}
+	errMethodNotAllowed      = errorCode{"method_not_allowed", http.StatusMethodNotAllowed, false}
+	errJobFinished           = errorCode{"job_finished", http.StatusConflict, false}
+	errTooLong               = errorCode{"too_long", http.StatusRequestEntityTooLarge, false}
+	errTooLarge              = errorCode{"too_large", http.StatusRequestEntityTooLarge, false}
+	errLabelAcknowledgement  = errorCode{"label_acknowledgement_required", http.StatusPreconditionRequired, false}
+	errRateLimited           = errorCode{"rate_limited", http.StatusTooManyRequests, true}
+	errQuotaExceeded         = errorCode{"quota_exceeded", http.StatusTooManyRequests, false}
+	errInternal              = errorCode{"internal_error", http.StatusInternalServerError, false}
+	errTranscodeFailed       = errorCode{"transcode_failed", http.StatusInternalServerError, false}
+	errUpstreamUnavailable   = errorCode{"upstream_unavailable", http.StatusBadGateway, true}
+	errVerificationFailed    = errorCode{"verification_failed", http.StatusBadGateway, false}
+	errServerBusy            = errorCode{"server_busy", http.StatusServiceUnavailable, true}
+	errShuttingDown          = errorCode{"shutting_down", http.StatusServiceUnavailable, true}
+)
+
+// jsonError responds with a JSON error body carrying a machine-readable code.
+func jsonError(w http.ResponseWriter, code errorCode, message string) {
+	retryError(w, code, message, 0)
+}
+
+// retryError is jsonError for errors that go away after retryAfter seconds, which
+// are sent in the Retry-After header and the body when positive.
+func retryError(w http.ResponseWriter, code errorCode, message string, retryAfter int) {
+	body := map[string]interface{}{
+		"code":      code.name,
+		"message":   message,
+		"retryable": code.retryable,
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		body["retryAfter"] = retryAfter
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code.status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// notFound responds to paths no route matches.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	jsonError(w, errNotFound, "Not found")
+}
+
+// methodNotAllowed responds to routes requested with a method they don't handle.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	jsonError(w, errMethodNotAllowed, "Method not allowed")
+}
+
+// downloadErrors maps the downloader's errors to responses, the first match wins.
+// Errors with a message set may carry upstream responses or ffmpeg output, which
+// clients never see; the text of the others is our own and is passed on.
+var downloadErrors = []struct {
+	err     error
+	code    errorCode
+	message string
+}{
+	{downloader.ErrInvalidRequest, errInvalidRequest, ""},
+	{downloader.ErrInvalidURL, errInvalidURL, "Not a valid Bluesky post URL"},
+	{downloader.ErrUnsupportedFormat, errUnsupportedFormat, ""},
+	{downloader.ErrResolutionUnavailable, errResolutionUnavailable, ""},
+	{downloader.ErrProfileNotFound, errProfileNotFound, "Could not resolve profile"},
+	{downloader.ErrPostNotFound, errPostNotFound, "Post not found"},
+	{downloader.ErrBlocked, errBlocked, "The author blocks, or is blocked by, the account this service uses"},
+	{downloader.ErrRejected, errInvalidRequest, "Bluesky rejected the request"},
+	{downloader.ErrNoVideo, errNoVideo, ""},
+	{downloader.ErrNoImages, errNoImages, ""},
+	{downloader.ErrNoExternalMedia, errNoExternalMedia, ""},
+	{downloader.ErrCaptionsUnavailable, errCaptionsUnavailable, ""},
+	{downloader.ErrTooLong, errTooLong, ""},
+	{downloader.ErrTooLarge, errTooLarge, ""},
+	{downloader.ErrBlobVerification, errVerificationFailed, "The video from the author's server did not match the post"},
+	{downloader.ErrExternalMediaFetch, errUpstreamUnavailable, "Could not fetch the external media, please try again later"},
+	{downloader.ErrUpstream, errUpstreamUnavailable, "Bluesky could not be reached, please try again later"},
+	{downloader.ErrTranscode, errTranscodeFailed, "Failed to convert the video"},
+}
+
+// describeError returns the code and message clients are shown for an error returned
+// by the downloader.
+func describeError(err error) (errorCode, string) {
+	var labelErr *downloader.LabelError
+	if errors.As(err, &labelErr) {
+		if labelErr.Refused() {
+			return errLabelRefused, err.Error()
+		}
+		return errLabelAcknowledgement, err.Error()
+	}
+
+	for _, known := range downloadErrors {
+		if errors.Is(err, known.err) {
+			if known.message == "" {
+				return known.code, err.Error()
+			}
+			return known.code, known.message
+		}
+	}
+	return errInternal, "Something went wrong, please try again later"
+}
+
+// downloadError responds with the error matching one returned by the downloader.
+func downloadError(w http.ResponseWriter, r *http.Request, err error) {
+	// The pipeline was cancelled because the client went away, there is nobody to respond to
+	if r.Context().Err() != nil {
+		slog.InfoContext(r.Context(), "Client disconnected, request cancelled", "error", err)
+		return
+	}
+
+	code, message := describeError(err)
+	slog.WarnContext(r.Context(), "Request failed", "status", code.status, "code", code.name, "error", err)
+	jsonError(w, code, message)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+)
+
+func TestDescribeError(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    errorCode
+		message string // Exact message, or the text it must not contain when hidden is set
+		hidden  bool
+	}{
+		{fmt.Errorf("%w: postID is required", downloader.ErrInvalidRequest), errInvalidRequest, "invalid request: postID is required", false},
+		{fmt.Errorf("%w: XRPC NotFound: Post not found: at://did:plc:x", downloader.ErrPostNotFound), errPostNotFound, "Post not found", false},
+		{fmt.Errorf("%w: XRPC BlockedByActor", downloader.ErrBlocked), errBlocked, "XRPC", true},
+		{fmt.Errorf("%w: XRPC InvalidRequest: bad cursor", downloader.ErrRejected), errInvalidRequest, "bad cursor", true},
+		{fmt.Errorf("%w: dial tcp 10.0.0.1:443: connection refused", downloader.ErrUpstream), errUpstreamUnavailable, "10.0.0.1", true},
+		{fmt.Errorf("%w: ffmpeg: exit status 1", downloader.ErrTranscode), errTranscodeFailed, "ffmpeg", true},
+		{fmt.Errorf("%w: 1.5GB over the 1GB limit", downloader.ErrTooLarge), errTooLarge, "output is larger than allowed: 1.5GB over the 1GB limit", false},
+		{errors.New("open /srv/videos/x.mp4: permission denied"), errInternal, "/srv/videos", true},
+	}
+
+	for _, tt := range tests {
+		code, message := describeError(tt.err)
+		if code != tt.code {
+			t.Errorf("describeError(%q) code = %s, want %s", tt.err, code.name, tt.code.name)
+		}
+		if tt.hidden && strings.Contains(message, tt.message) {
+			t.Errorf("describeError(%q) message = %q, leaks %q", tt.err, message, tt.message)
+		}
+		if !tt.hidden && message != tt.message {
+			t.Errorf("describeError(%q) message = %q, want %q", tt.err, message, tt.message)
+		}
+	}
+}
+
+func TestDescribeLabelError(t *testing.T) {
+	refused := &downloader.LabelError{Decision: bsky.LabelDecision{Action: bsky.LabelRefuse, Labels: []string{"!takedown"}}}
+	if code, _ := describeError(fmt.Errorf("download: %w", refused)); code != errLabelRefused {
+		t.Errorf("refused post code = %s, want %s", code.name, errLabelRefused.name)
+	}
+
+	acknowledge := &downloader.LabelError{Decision: bsky.LabelDecision{Action: bsky.LabelAcknowledge, Labels: []string{"porn"}}}
+	if code, _ := describeError(acknowledge); code != errLabelAcknowledgement {
+		t.Errorf("labelled post code = %s, want %s", code.name, errLabelAcknowledgement.name)
+	}
+}
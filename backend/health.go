@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/storage"
+)
+
+// checkResult is the outcome of one readiness check.
+type checkResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // "ok", "fail" or "skipped"
+	DurationMs float64 `json:"durationMs"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// readinessCheck verifies one dependency. run returns nil when it is fine, errSkipped
+// when it isn't checked, or an error describing what is wrong.
+type readinessCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+var errSkipped = errors.New("skipped")
+
+// healthz reports that the process is up and serving requests.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyz runs every readiness check at once and responds with 200 if all of them
+// pass, 503 otherwise, listing each check's result and how long it took.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.Health.Timeout)
+	defer cancel()
+
+	checks := readinessChecks()
+	results := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check.run(ctx)
+
+			result := checkResult{Name: check.name, Status: "ok"}
+			switch {
+			case errors.Is(err, errSkipped):
+				result.Status = "skipped"
+			case err != nil:
+				result.Status = "fail"
+				result.Message = err.Error()
+			}
+			result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, result := range results {
+		if result.Status == "fail" {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": results,
+	})
+}
+
+func readinessChecks() []readinessCheck {
+	store := storage.New(dl.WorkDir())
+	return []readinessCheck{
+		{"shutdown", func(ctx context.Context) error {
+			if jobs.Stopping() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		}},
+		{"ffmpeg", dl.CheckTranscoder},
+		{"storage", func(ctx context.Context) error {
+			if err := store.CheckWritable(); err != nil {
+				return err
+			}
+			free, err := store.FreeSpace()
+			if errors.Is(err, errors.ErrUnsupported) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read free space: %v", err)
+			}
+			if need := uint64(cfg.Health.MinFreeMB) << 20; free < need {
+				return fmt.Errorf("%d MB free, %d MB required", free>>20, cfg.Health.MinFreeMB)
+			}
+			return nil
+		}},
+		{"queue", func(ctx context.Context) error {
+			if queue.Saturated() {
+				active, queued := queue.Stats()
+				return fmt.Errorf("queue is full with %d running and %d waiting", active, queued)
+			}
+			return nil
+		}},
+		{"appview", func(ctx context.Context) error {
+			if !cfg.Health.CheckAppView {
+				return errSkipped
+			}
+			return dl.CheckAppView(ctx)
+		}},
+	}
+}
@@ -0,0 +1,418 @@
+// Package hls reads the HLS playlists Bluesky serves videos and captions from
+// and downloads their segments.
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+	"github.com/Rudra644/bluesky_downloader/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrResolutionNotFound is returned when the master playlist has no rendition with the requested height.
+var ErrResolutionNotFound = errors.New("resolution not found in playlist")
+
+// Client fetches playlists and segments.
+type Client struct {
+	HTTPClient  *http.Client
+	Concurrency int                // Segments downloaded in parallel per rendition
+	Limiter     *scheduler.Limiter // Caps segment fetches across all downloads, nil for no limit
+	Retries     int                // Extra attempts for a segment after a network error or 5xx
+}
+
+// NewClient returns a client that downloads four segments at a time and retries
+// each failed segment twice. A playlist or segment that takes longer than a minute
+// to fetch fails.
+func NewClient() *Client {
+	return &Client{HTTPClient: &http.Client{Timeout: time.Minute}, Concurrency: 4, Retries: 2}
+}
+
+// Resolutions lists the renditions in the master playlist (e.g. "720p"), lowest first.
+func (c *Client) Resolutions(ctx context.Context, playlistURL string) ([]string, error) {
+	slog.DebugContext(ctx, "Fetching master playlist", "url", playlistURL)
+
+	body, err := c.fetchText(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(body, "\n")
+	resolutionMap := make(map[string]string)
+
+	for _, line := range lines {
+		userResolution, dimension, ok := parseResolution(strings.TrimSpace(line))
+		if ok {
+			resolutionMap[userResolution] = dimension
+		}
+	}
+
+	slog.DebugContext(ctx, "Resolution map", "resolutions", resolutionMap)
+
+	var resolutions []string
+	for resolution := range resolutionMap {
+		resolutions = append(resolutions, resolution)
+	}
+	sort.Slice(resolutions, func(i, j int) bool { return height(resolutions[i]) < height(resolutions[j]) })
+	return resolutions, nil
+}
+
+// PickResolution returns the requested resolution if it is available, otherwise the highest one.
+func PickResolution(available []string, requested string) string {
+	best := ""
+	bestHeight := -1
+	for _, resolution := range available {
+		if resolution == requested {
+			return resolution
+		}
+		if h := height(resolution); h > bestHeight {
+			best = resolution
+			bestHeight = h
+		}
+	}
+	return best
+}
+
+// parseResolution reads the RESOLUTION attribute of a stream info line, returning it in
+// user-friendly form (e.g. 720p) and as dimensions (e.g. 1280x720). Lines without a
+// well-formed attribute are skipped.
+func parseResolution(line string) (userResolution, dimension string, ok bool) {
+	_, value, found := strings.Cut(line, "RESOLUTION=")
+	if !found {
+		return "", "", false
+	}
+	dimension, _, _ = strings.Cut(value, ",")
+
+	width, lines, found := strings.Cut(dimension, "x")
+	if !found {
+		return "", "", false
+	}
+	for _, number := range []string{width, lines} {
+		if n, err := strconv.Atoi(number); err != nil || n <= 0 {
+			return "", "", false
+		}
+	}
+	return lines + "p", dimension, true
+}
+
+// height returns the number of lines of a resolution like "720p", or -1 for anything else.
+func height(resolution string) int {
+	h, err := strconv.Atoi(strings.TrimSuffix(resolution, "p"))
+	if err != nil {
+		return -1
+	}
+	return h
+}
+
+// DownloadRendition downloads every segment of the requested resolution into dir and
+// returns their paths in playback order. progress, if set, is called after each segment.
+// If a segment fails or ctx is cancelled, the segments already written are removed.
+func (c *Client) DownloadRendition(ctx context.Context, playlistURL, userResolution, dir string, progress func(done, total int)) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "hls.DownloadRendition", attribute.String("resolution", userResolution))
+	defer func() { tracing.End(span, err) }()
+
+	// Ensure the post directory exists
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+
+	slog.DebugContext(ctx, "Fetching master playlist", "url", playlistURL)
+
+	body, err := c.fetchText(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "Master playlist content", "body", body)
+
+	// Parse the .m3u8 file
+	lines := strings.Split(body, "\n")
+	slog.DebugContext(ctx, "Parsing master playlist", "resolution", userResolution)
+
+	var resolutionURL string
+	baseURL := playlistURL[:strings.LastIndex(playlistURL, "/")+1] // Extract base URL
+
+	// Iterate through lines to find the desired resolution and its URL
+	for i := 0; i < len(lines)-1; i++ {
+		mappedResolution, _, ok := parseResolution(strings.TrimSpace(lines[i]))
+		if ok && mappedResolution == userResolution {
+			nextLine := strings.TrimSpace(lines[i+1])
+			if nextLine != "" && !strings.HasPrefix(nextLine, "#") {
+				resolutionURL = nextLine
+				break
+			}
+		}
+	}
+
+	if resolutionURL == "" {
+		return nil, fmt.Errorf("%w: %s", ErrResolutionNotFound, userResolution)
+	}
+
+	if !strings.HasPrefix(resolutionURL, "http") {
+		resolutionURL = baseURL + resolutionURL
+	}
+
+	slog.DebugContext(ctx, "Resolved rendition playlist", "url", resolutionURL)
+
+	segmentFiles, err := c.downloadSegments(ctx, resolutionURL, dir, progress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process resolution %s: %v", userResolution, err)
+	}
+	return segmentFiles, nil
+}
+
+// Duration returns the running time of a video from the segment durations of its first rendition.
+func (c *Client) Duration(ctx context.Context, playlistURL string) (time.Duration, error) {
+	body, err := c.fetchText(ctx, playlistURL)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every rendition covers the whole video, so the first one will do
+	var renditionURL string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			renditionURL = line
+			break
+		}
+	}
+	if renditionURL == "" {
+		return 0, fmt.Errorf("no renditions in playlist")
+	}
+	if !strings.HasPrefix(renditionURL, "http") {
+		renditionURL = playlistURL[:strings.LastIndex(playlistURL, "/")+1] + renditionURL
+	}
+
+	body, err = c.fetchText(ctx, renditionURL)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXTINF:") {
+			continue
+		}
+		// #EXTINF:<seconds>,[title]
+		value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid segment duration %q", value)
+		}
+		total += seconds
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+type segment struct {
+	index int
+	url   string
+	path  string
+}
+
+func (c *Client) downloadSegments(ctx context.Context, resolutionURL, dir string, progress func(done, total int)) ([]string, error) {
+	slog.DebugContext(ctx, "Fetching rendition playlist", "url", resolutionURL)
+
+	body, err := c.fetchText(ctx, resolutionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(body, "\n")
+	baseURL := resolutionURL[:strings.LastIndex(resolutionURL, "/")+1]
+
+	var segments []segment
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") && line != "" {
+			if !strings.HasPrefix(line, "http") {
+				line = baseURL + line
+			}
+			segments = append(segments, segment{index: i, url: line, path: filepath.Join(dir, fmt.Sprintf("segment-%d.ts", i))})
+		}
+	}
+
+	if progress != nil {
+		progress(0, len(segments))
+	}
+
+	// Fetch several segments at once, stopping at the first failure
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		done     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	queue := make(chan segment)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seg := range queue {
+				err := c.downloadSegment(ctx, seg)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					done++
+					if progress != nil {
+						progress(done, len(segments))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, seg := range segments {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		select {
+		case queue <- seg:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		// Don't leave a partial rendition behind
+		for _, seg := range segments {
+			os.Remove(seg.path)
+		}
+		return nil, firstErr
+	}
+
+	var segmentFiles []string
+	for _, seg := range segments {
+		segmentFiles = append(segmentFiles, seg.path)
+	}
+	return segmentFiles, nil
+}
+
+// retryableError marks a segment failure that may go away when the segment is fetched again.
+type retryableError struct{ error }
+
+// downloadSegment fetches a segment, retrying network errors and server errors with
+// a growing delay.
+func (c *Client) downloadSegment(ctx context.Context, seg segment) error {
+	for attempt := 0; ; attempt++ {
+		err := c.fetchSegment(ctx, seg, attempt+1)
+		var retryable retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= c.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		metrics.SegmentRetries.Inc()
+		slog.WarnContext(ctx, "Retrying segment", "index", seg.index, "attempt", attempt+1, "error", err)
+
+		select {
+		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) fetchSegment(ctx context.Context, seg segment, attempt int) (err error) {
+	ctx, span := tracing.Start(ctx, "hls.fetchSegment", attribute.Int("segment.index", seg.index), attribute.Int("attempt", attempt))
+	defer func() { tracing.End(span, err) }()
+
+	if err := c.Limiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer c.Limiter.Release()
+
+	slog.DebugContext(ctx, "Downloading segment", "index", seg.index, "url", seg.url)
+
+	resp, err := c.get(ctx, seg.url)
+	if err != nil {
+		return retryableError{fmt.Errorf("failed to download segment %d: %v", seg.index, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return retryableError{fmt.Errorf("segment %d returned status code: %d", seg.index, resp.StatusCode)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("segment %d returned status code: %d", seg.index, resp.StatusCode)
+	}
+
+	out, err := os.Create(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to create segment file: %v", err)
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, resp.Body)
+	metrics.CDNBytes.WithLabelValues("segment").Add(float64(written))
+	span.SetAttributes(attribute.Int64("bytes", written))
+	if err != nil {
+		return retryableError{fmt.Errorf("failed to save segment %d: %v", seg.index, err)}
+	}
+	return nil
+}
+
+// get sends a GET request that is abandoned when ctx is cancelled.
+func (c *Client) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.HTTPClient.Do(req)
+}
+
+func (c *Client) fetchText(ctx context.Context, textURL string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "hls.fetchText", attribute.String("url.full", textURL))
+	defer func() { tracing.End(span, err) }()
+
+	resp, err := c.get(ctx, textURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", textURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status code: %d", textURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", textURL, err)
+	}
+	span.SetAttributes(attribute.Int("bytes", len(body)))
+	return string(body), nil
+}
@@ -0,0 +1,42 @@
+package hls
+
+import "testing"
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		line           string
+		userResolution string
+		dimension      string
+		ok             bool
+	}{
+		{`#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS="avc1.64001f"`, "720p", "1280x720", true},
+		{`#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360`, "360p", "640x360", true},
+		{`#EXT-X-STREAM-INF:BANDWIDTH=800000`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=1280`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=1280x`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=x720`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=widexhigh`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=1280x720x3`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=1280x-720`, "", "", false},
+		{`#EXT-X-STREAM-INF:RESOLUTION=0x0`, "", "", false},
+	}
+
+	for _, tt := range tests {
+		userResolution, dimension, ok := parseResolution(tt.line)
+		if userResolution != tt.userResolution || dimension != tt.dimension || ok != tt.ok {
+			t.Errorf("parseResolution(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.line, userResolution, dimension, ok, tt.userResolution, tt.dimension, tt.ok)
+		}
+	}
+}
+
+func TestPickResolution(t *testing.T) {
+	available := []string{"360p", "720p", "1080p"}
+	if got := PickResolution(available, "720p"); got != "720p" {
+		t.Errorf("PickResolution(720p) = %q, want 720p", got)
+	}
+	if got := PickResolution(available, "480p"); got != "1080p" {
+		t.Errorf("PickResolution(480p) = %q, want the highest, 1080p", got)
+	}
+}
@@ -0,0 +1,134 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SubtitleRendition is a subtitle track listed in the master playlist.
+type SubtitleRendition struct {
+	Lang string
+	URI  string
+}
+
+var (
+	attributeRegex = regexp.MustCompile(`([A-Z0-9-]+)=("[^"]*"|[^,]*)`)
+	vttTimingRegex = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}\.\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}\.\d{3})`)
+)
+
+// Subtitles returns the subtitle renditions listed in the master playlist.
+func (c *Client) Subtitles(ctx context.Context, playlistURL string) ([]SubtitleRendition, error) {
+	body, err := c.fetchText(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := playlistURL[:strings.LastIndex(playlistURL, "/")+1]
+	var renditions []SubtitleRendition
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+
+		attributes := make(map[string]string)
+		for _, match := range attributeRegex.FindAllStringSubmatch(strings.TrimPrefix(line, "#EXT-X-MEDIA:"), -1) {
+			attributes[match[1]] = strings.Trim(match[2], `"`)
+		}
+		if attributes["TYPE"] != "SUBTITLES" || attributes["URI"] == "" {
+			continue
+		}
+
+		uri := attributes["URI"]
+		if !strings.HasPrefix(uri, "http") {
+			uri = baseURL + uri
+		}
+		lang := attributes["LANGUAGE"]
+		if lang == "" {
+			lang = attributes["NAME"]
+		}
+		renditions = append(renditions, SubtitleRendition{Lang: lang, URI: uri})
+	}
+	return renditions, nil
+}
+
+// DownloadSubtitles merges the WebVTT segments of a subtitle rendition into a single file.
+func (c *Client) DownloadSubtitles(ctx context.Context, renditionURL, outputPath string) error {
+	body, err := c.fetchText(ctx, renditionURL)
+	if err != nil {
+		return err
+	}
+
+	baseURL := renditionURL[:strings.LastIndex(renditionURL, "/")+1]
+	var merged strings.Builder
+	merged.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "http") {
+			line = baseURL + line
+		}
+
+		segment, err := c.fetchText(ctx, line)
+		if err != nil {
+			return fmt.Errorf("failed to fetch caption segment: %v", err)
+		}
+		merged.WriteString(vttCues(segment))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create caption directory: %v", err)
+	}
+	if err := os.WriteFile(outputPath, []byte(merged.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write caption file: %v", err)
+	}
+	return nil
+}
+
+// vttCues strips the header and metadata blocks of a WebVTT file, leaving only its cues.
+func vttCues(vtt string) string {
+	var cues strings.Builder
+	for _, block := range strings.Split(strings.ReplaceAll(vtt, "\r\n", "\n"), "\n\n") {
+		block = strings.TrimSpace(block)
+		if block == "" || strings.HasPrefix(block, "WEBVTT") || strings.HasPrefix(block, "NOTE") || strings.HasPrefix(block, "STYLE") || strings.HasPrefix(block, "REGION") {
+			continue
+		}
+		cues.WriteString(block + "\n\n")
+	}
+	return cues.String()
+}
+
+// VTTToSRT converts WebVTT cues to SubRip, dropping cue settings and identifiers.
+func VTTToSRT(vtt string) string {
+	var srt strings.Builder
+	index := 0
+	for _, block := range strings.Split(vttCues(vtt), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		for i, line := range lines {
+			match := vttTimingRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			index++
+			fmt.Fprintf(&srt, "%d\n%s --> %s\n", index, srtTimestamp(match[1]), srtTimestamp(match[2]))
+			srt.WriteString(strings.Join(lines[i+1:], "\n") + "\n\n")
+			break
+		}
+	}
+	return srt.String()
+}
+
+// srtTimestamp turns a WebVTT timestamp (hours optional, dot separator) into an SRT one.
+func srtTimestamp(ts string) string {
+	if strings.Count(ts, ":") == 1 {
+		ts = "00:" + ts
+	}
+	return strings.Replace(ts, ".", ",", 1)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// downloadImagesHandler downloads one image of a post, or all of them as a zip.
+func downloadImagesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Profile string `json:"profile"`
+		PostID  string `json:"postID"`
+		Index   *int   `json:"index"`  // Zero-based image to download, all images when omitted
+		Format  string `json:"format"` // "original", "jpeg", "png" or "webp"
+
+		AcknowledgeLabels bool `json:"acknowledgeLabels"` // Required for posts the label policy flags
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		jsonError(w, errInvalidRequest, "Invalid request")
+		slog.WarnContext(r.Context(), "Error decoding request body", "error", err)
+		return
+	}
+
+	if input.Profile == "" || input.PostID == "" {
+		jsonError(w, errInvalidRequest, "Profile and PostID are required")
+		return
+	}
+
+	if !checkKeyQuota(w, r, input.Format, "original") {
+		return
+	}
+
+	req := DownloadRequest{
+		Type:              "images",
+		Profile:           input.Profile,
+		PostID:            input.PostID,
+		Index:             input.Index,
+		Format:            input.Format,
+		AcknowledgeLabels: input.AcknowledgeLabels,
+	}
+
+	// Wait for a free slot so concurrent downloads don't overload the server
+	ticket := schedule(w, r, req)
+	if ticket == nil {
+		return
+	}
+	defer ticket.Done()
+
+	result, err := req.run(r.Context())
+	if err != nil {
+		downloadError(w, r, err)
+		return
+	}
+
+	recordDownload(r, result)
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"message":  "Images processed successfully",
+		"filename": fileURL(result),
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/logging"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+	"github.com/gorilla/mux"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+type JobItem struct {
+	Profile   string    `json:"profile"`
+	PostID    string    `json:"postID"`
+	Status    JobStatus `json:"status"`
+	Skipped   bool      `json:"skipped,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	CID       string    `json:"cid,omitempty"` // Verified CID of the original blob
+	Warnings  []string  `json:"warnings,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode string    `json:"errorCode,omitempty"`
+}
+
+type Job struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Status    JobStatus `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Total     int       `json:"total"`
+	Completed int       `json:"completed"`
+	Skipped   int       `json:"skipped"`
+	Failed    int       `json:"failed"`
+	Items     []JobItem `json:"items"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode string    `json:"errorCode,omitempty"`
+
+	QueuePosition int `json:"queuePosition,omitempty"` // Place of the next item in the download queue while it waits
+
+	owner string // Who may look the job up and cancel it, see jobOwner
+}
+
+// fail marks the item as failed with the code and message clients are shown for err.
+func (item *JobItem) fail(err error) {
+	code, message := describeError(err)
+	item.Status = JobFailed
+	item.ErrorCode, item.Error = code.name, message
+}
+
+// Finished reports whether the job has stopped running.
+func (s JobStatus) Finished() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCancelled
+}
+
+// savedJob is an unfinished job as it is persisted on shutdown, with what it takes to resume it.
+type savedJob struct {
+	Job     Job         `json:"job"`
+	Client  string      `json:"client"`
+	KeyID   string      `json:"keyID,omitempty"`
+	Request BulkRequest `json:"request"`
+
+	Download *DownloadRequest `json:"download,omitempty"` // Set instead of Request for a queued download
+}
+
+// JobStore keeps track of background jobs in memory.
+type JobStore struct {
+	mu       sync.RWMutex
+	jobs     map[string]*Job
+	cancels  map[string]context.CancelFunc
+	tickets  map[string]*scheduler.Ticket // Queue ticket of the item each job is waiting on
+	resume   map[string]savedJob          // How to restart each unfinished job, without the Job itself
+	running  sync.WaitGroup
+	stopping bool
+	stopped  chan struct{} // Closed by Stop
+}
+
+var jobs = &JobStore{
+	jobs:    make(map[string]*Job),
+	cancels: make(map[string]context.CancelFunc),
+	tickets: make(map[string]*scheduler.Ticket),
+	resume:  make(map[string]savedJob),
+	stopped: make(chan struct{}),
+}
+
+// Create registers a new queued job of the given type for owner. The returned context
+// keeps the values of ctx, so the job logs with the request ID of the request that
+// started it, but is only cancelled by Cancel. It should be passed to everything the job runs.
+func (s *JobStore) Create(ctx context.Context, jobType, owner string) (*Job, context.Context) {
+	now := time.Now()
+	job := &Job{
+		ID:        newJobID(),
+		Type:      jobType,
+		Status:    JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		owner:     owner,
+	}
+	return job, s.add(ctx, job)
+}
+
+// Restore registers a job saved by an earlier run as queued again, keeping its ID and items.
+func (s *JobStore) Restore(saved Job, owner string) (*Job, context.Context) {
+	job := saved
+	job.owner = owner
+	job.Status = JobQueued
+	job.QueuePosition = 0
+	job.UpdatedAt = time.Now()
+	return &job, s.add(context.Background(), &job)
+}
+
+func (s *JobStore) add(ctx context.Context, job *Job) context.Context {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	ctx = logging.WithAttrs(ctx, "job_id", job.ID)
+
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+	return ctx
+}
+
+// Run runs fn, the body of a job, in the background. Wait waits for it.
+func (s *JobStore) Run(fn func()) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		fn()
+	}()
+}
+
+// SetResume records how to restart the job if the server stops before it finishes.
+func (s *JobStore) SetResume(id string, saved savedJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resume[id] = saved
+}
+
+// Stop tells running jobs to stop once their current item is done, so they can be
+// saved and resumed after a restart.
+func (s *JobStore) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopping {
+		s.stopping = true
+		close(s.stopped)
+	}
+}
+
+// Stopped returns a channel that is closed once Stop has been called.
+func (s *JobStore) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+// Stopping reports whether the server is shutting down.
+func (s *JobStore) Stopping() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stopping
+}
+
+// Interrupt cancels the contexts of all running jobs without marking them cancelled,
+// aborting the items in progress.
+func (s *JobStore) Interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+}
+
+// Wait waits until every job started with Run has returned or ctx is done.
+func (s *JobStore) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unfinished returns the jobs that can be resumed after a restart.
+func (s *JobStore) Unfinished() []savedJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []savedJob
+	for id, saved := range s.resume {
+		job, ok := s.jobs[id]
+		if !ok || job.Status.Finished() {
+			continue
+		}
+		saved.Job = *job
+		saved.Job.Items = append([]JobItem(nil), job.Items...)
+		list = append(list, saved)
+	}
+	return list
+}
+
+// Cancel marks a queued or running job as cancelled and cancels its context.
+// It returns false if the job has already finished.
+func (s *JobStore) Cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Status.Finished() {
+		return false
+	}
+	job.Status = JobCancelled
+	job.UpdatedAt = time.Now()
+	s.release(id)
+	return true
+}
+
+// Finish sets the final status of a job, and the error it failed with if not nil,
+// unless it has been cancelled in the meantime.
+func (s *JobStore) Finish(id string, status JobStatus, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok && job.Status != JobCancelled {
+		job.Status = status
+		if err != nil {
+			code, message := describeError(err)
+			job.ErrorCode, job.Error = code.name, message
+		}
+		job.UpdatedAt = time.Now()
+	}
+	s.release(id)
+}
+
+// release cancels the job's context and forgets it. The caller must hold the lock.
+func (s *JobStore) release(id string) {
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+	delete(s.resume, id)
+}
+
+// Get returns a copy of the job so it can be read while the job keeps running.
+func (s *JobStore) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	snapshot := *job
+	snapshot.Items = append([]JobItem(nil), job.Items...)
+	if ticket, ok := s.tickets[id]; ok {
+		snapshot.QueuePosition = ticket.Position()
+	}
+	return snapshot, true
+}
+
+// Sweep forgets the jobs that finished more than ttl ago and returns how many there were.
+func (s *JobStore) Sweep(ttl time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	swept := 0
+	for id, job := range s.jobs {
+		if job.Status.Finished() && time.Since(job.UpdatedAt) > ttl {
+			delete(s.jobs, id)
+			delete(s.tickets, id)
+			swept++
+		}
+	}
+	return swept
+}
+
+// SetTicket records the queue ticket the job is waiting on, or clears it when ticket is nil.
+func (s *JobStore) SetTicket(id string, ticket *scheduler.Ticket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ticket == nil {
+		delete(s.tickets, id)
+	} else {
+		s.tickets[id] = ticket
+	}
+}
+
+// Update applies fn to the job while holding the store lock.
+func (s *JobStore) Update(id string, fn func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobOwner identifies who a job started by the request belongs to: the API key it
+// was made with or, without one, the client.
+func jobOwner(r *http.Request) string {
+	if key := requestKey(r); key != nil {
+		return "key:" + key.ID
+	}
+	return "client:" + clientID(r)
+}
+
+// owner is jobOwner for the request that started a saved job.
+func (s savedJob) owner() string {
+	if s.KeyID != "" {
+		return "key:" + s.KeyID
+	}
+	return "client:" + s.Client
+}
+
+// visibleJob returns the job if the request may see it: only its owner and admin keys
+// can, everyone else is told it doesn't exist.
+func visibleJob(r *http.Request, id string) (Job, bool) {
+	job, ok := jobs.Get(id)
+	if !ok {
+		return Job{}, false
+	}
+	if key := requestKey(r); key != nil && key.Admin {
+		return job, true
+	}
+	return job, job.owner == jobOwner(r)
+}
+
+// getJob reports the current status of a background job.
+func getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := visibleJob(r, mux.Vars(r)["id"])
+	if !ok {
+		jsonError(w, errJobNotFound, "Job not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// cancelJob stops a queued or running background job. Items that were already
+// downloaded are kept; the one in progress is aborted and its partial files removed.
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := visibleJob(r, id); !ok {
+		jsonError(w, errJobNotFound, "Job not found")
+		return
+	}
+	if !jobs.Cancel(id) {
+		jsonError(w, errJobFinished, "Job has already finished")
+		return
+	}
+	slog.InfoContext(r.Context(), "Cancelled job", "job_id", id)
+
+	job, _ := jobs.Get(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
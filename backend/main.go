@@ -1,39 +1,38 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Rudra644/bluesky_downloader/apikeys"
+	"github.com/Rudra644/bluesky_downloader/bsky"
+	"github.com/Rudra644/bluesky_downloader/config"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+	"github.com/Rudra644/bluesky_downloader/logging"
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/ratelimit"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/Rudra644/bluesky_downloader/tracing"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
 type UserData struct {
-	URL string `json:"url"`
-}
-
-type PostDetails struct {
-	Cid         string `json:"cid"`
-	Playlist    string `json:"playlist"`
-	Thumbnail   string `json:"thumbnail"`
-	Title       string `json:"title"`
-	LikeCount   int    `json:"likeCount"`
-	ReplyCount  int    `json:"replyCount"`
-	RepostCount int    `json:"repostCount"`
-	AspectRatio *AspectRatio
-}
-
-type AspectRatio struct {
-	Height int `json:"height"`
-	Width  int `json:"width"`
+	URL    string `json:"url"`
+	Thread bool   `json:"thread"` // Collect every video the author posted in the thread
 }
 
 func TestHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,30 +41,15 @@ func TestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Working"))
 }
 
-// EnsureDirectories ensures the root videos directory and post-specific subdirectory exist.
-func EnsureDirectories(postID string) (string, error) {
-	rootDir := "videos"
-
-	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
-		fmt.Println("Creating root directory:", rootDir)
-		err := os.Mkdir(rootDir, 0755)
-		if err != nil {
-			return "", fmt.Errorf("failed to create root directory: %v", err)
-		}
-	}
-
-	postDir := filepath.Join(rootDir, postID)
-	fmt.Println("Ensuring post-specific directory:", postDir)
+// cfg is the server configuration. main replaces it once it has been loaded.
+var cfg = config.Default()
 
-	if _, err := os.Stat(postDir); os.IsNotExist(err) {
-		err := os.Mkdir(postDir, 0755)
-		if err != nil {
-			return "", fmt.Errorf("failed to create post directory: %v", err)
-		}
-		fmt.Println("Created post directory:", postDir)
-	}
+// dl runs every download. main replaces it once the configuration has been read.
+var dl = downloader.New()
 
-	return postDir, nil
+// fileURL returns the URL a file in the work dir is served from.
+func fileURL(result *downloader.Result) string {
+	return fmt.Sprintf("%s/videos/%s", strings.TrimSuffix(cfg.Server.BaseURL, "/"), result.RelPath)
 }
 
 func process(w http.ResponseWriter, r *http.Request) {
@@ -73,100 +57,94 @@ func process(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		fmt.Println("Error decoding request body:", err)
+		jsonError(w, errInvalidRequest, "Invalid request")
+		slog.WarnContext(r.Context(), "Error decoding request body", "error", err)
 		return
 	}
 
 	if input.URL == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
-		fmt.Println("Error: URL is missing in the request")
+		jsonError(w, errInvalidRequest, "URL is required")
 		return
 	}
 
-	profile, postID, err := extractPostDetails(input.URL)
-	if err != nil {
-		http.Error(w, "Invalid URL format", http.StatusBadRequest)
-		fmt.Println("Error extracting post details:", err)
+	if input.Thread {
+		processThread(w, r, input.URL)
 		return
 	}
 
-	postDetails, err := FetchPostMetadata(profile, postID)
+	info, err := dl.Inspect(r.Context(), input.URL)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching metadata: %v", err), http.StatusInternalServerError)
-		fmt.Println("Error fetching post metadata:", err)
+		downloadError(w, r, err)
 		return
 	}
 
-	resolutions, err := fetchAvailableResolutions(postDetails.Playlist)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching resolutions: %v", err), http.StatusInternalServerError)
-		fmt.Println("Error fetching resolutions:", err)
+	// Don't offer posts the label policy refuses outright
+	if info.Moderation.Action == bsky.LabelRefuse {
+		downloadError(w, r, &downloader.LabelError{Decision: info.Moderation})
 		return
 	}
 
 	response := map[string]interface{}{
-		"profile":     profile,
-		"postID":      postID,
-		"thumbnail":   postDetails.Thumbnail,
-		"title":       truncateTitle(postDetails.Title),
-		"likeCount":   postDetails.LikeCount,
-		"replyCount":  postDetails.ReplyCount,
-		"repostCount": postDetails.RepostCount,
-		"resolutions": getResolutionKeys(resolutions),
+		"profile":     info.Profile,
+		"handle":      info.Handle,
+		"postID":      info.PostID,
+		"uri":         info.URI,
+		"title":       bsky.TruncateTitle(info.Details.Title),
+		"likeCount":   info.Details.LikeCount,
+		"replyCount":  info.Details.ReplyCount,
+		"repostCount": info.Details.RepostCount,
+		"mediaType":   info.Details.MediaType,
+		"moderation":  info.Moderation,
+	}
+
+	switch info.Details.MediaType {
+	case "video":
+		response["thumbnail"] = info.Details.Thumbnail
+		response["resolutions"] = info.Resolutions
+		response["captions"] = info.Captions
+		slog.DebugContext(r.Context(), "Resolutions in response", "resolutions", info.Resolutions)
+	case "images":
+		response["thumbnail"] = info.Details.Images[0].Thumb
+		response["images"] = info.Details.Images
+		response["imageFormats"] = []string{"original", "jpeg", "png", "webp"}
+	case "external":
+		response["thumbnail"] = info.Details.External.Thumb
+		response["external"] = info.Details.External
+		response["formats"] = []string{"mp4", "gif", "webp"}
+	default:
+		jsonError(w, errNoVideo, "Post has no video or images")
+		slog.InfoContext(r.Context(), "Post has no downloadable media", "uri", info.URI)
+		return
 	}
 
-	fmt.Printf("Resolutions in response: %+v\n", resolutions)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// Helper function to extract keys from the map
-func getResolutionKeys(resolutionMap map[string]string) []string {
-	var keys []string
-	for key := range resolutionMap {
-		keys = append(keys, key)
-	}
-	return keys
-}
-
-func fetchAvailableResolutions(playlistURL string) (map[string]string, error) {
-	fmt.Println("Fetching master playlist:", playlistURL)
-
-	resp, err := http.Get(playlistURL)
+// processThread responds with the ordered list of videos the author posted in the thread.
+func processThread(w http.ResponseWriter, r *http.Request, postURL string) {
+	profile, postID, err := dl.ResolveURL(r.Context(), postURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch .m3u8 file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("master playlist returned status code: %d", resp.StatusCode)
+		downloadError(w, r, err)
+		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	videos, err := dl.InspectThread(r.Context(), profile, postID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read .m3u8 file: %v", err)
+		downloadError(w, r, err)
+		return
 	}
 
-	lines := strings.Split(string(body), "\n")
-	resolutionMap := make(map[string]string)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "RESOLUTION=") {
-			resolutionStr := strings.Split(line, "RESOLUTION=")[1]
-			dimension := strings.Split(resolutionStr, ",")[0]
-
-			// Convert to user-friendly format (e.g., 360p, 720p)
-			height := strings.Split(dimension, "x")[1]
-			userResolution := fmt.Sprintf("%sp", height)
-			resolutionMap[userResolution] = dimension
-		}
+	response := map[string]interface{}{
+		"profile": profile,
+		"postID":  postID,
+		"uri":     bsky.PostURI(profile, postID),
+		"thread":  true,
+		"videos":  videos,
 	}
 
-	fmt.Println("Resolution map:", resolutionMap)
-	return resolutionMap, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func download(w http.ResponseWriter, r *http.Request) {
@@ -175,517 +153,348 @@ func download(w http.ResponseWriter, r *http.Request) {
 		PostID     string `json:"postID"`
 		Resolution string `json:"resolution"`
 		Format     string `json:"format"` // New field for desired format
+		Thread     bool   `json:"thread"` // Download every video the author posted in the thread
+		Bundle     string `json:"bundle"` // How thread videos are delivered: "zip" or "concat"
+
+		AcknowledgeLabels bool     `json:"acknowledgeLabels"` // Required for posts the label policy flags
+		Captions          []string `json:"captions"`          // Caption languages to mux as soft subtitles
+
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		fmt.Printf("Error decoding request body: %v\n", err)
+		jsonError(w, errInvalidRequest, "Invalid request")
+		slog.WarnContext(r.Context(), "Error decoding request body", "error", err)
 		return
 	}
 
 	// Validate required fields
 	if input.Profile == "" || input.PostID == "" || input.Resolution == "" {
-		http.Error(w, "Profile, PostID, and Resolution are required", http.StatusBadRequest)
-		fmt.Printf("Missing parameters: Profile=%s, PostID=%s, Resolution=%s\n", input.Profile, input.PostID, input.Resolution)
+		jsonError(w, errInvalidRequest, "Profile, PostID, and Resolution are required")
 		return
 	}
 
-	// Default format to MP4 if not provided
-	if input.Format == "" {
-		input.Format = "mp4"
-	}
-
-	// Validate format
-	if input.Format != "mp4" && input.Format != "ts" {
-		http.Error(w, "Invalid format. Only 'mp4' and 'ts' are supported.", http.StatusBadRequest)
-		fmt.Printf("Invalid format: %s\n", input.Format)
+	// Thread bundles are processed without captions
+	if len(input.Captions) > 0 && input.Thread {
+		jsonError(w, errInvalidRequest, "Captions can only be muxed into single 'mp4' or 'mkv' downloads.")
 		return
 	}
 
-	fmt.Printf("Processing video for Profile: %s, PostID: %s, Resolution: %s, Format: %s\n",
-		input.Profile, input.PostID, input.Resolution, input.Format)
-
-	// Fetch metadata
-	postDetails, err := FetchPostMetadata(input.Profile, input.PostID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching metadata: %v", err), http.StatusInternalServerError)
-		fmt.Printf("Error fetching metadata: %v\n", err)
+	if !checkKeyQuota(w, r, input.Format, "mp4") {
 		return
 	}
 
-	// Paths for processing
-	tempDir := filepath.Join("videos", input.PostID)
-	videoPath := filepath.Join(tempDir, fmt.Sprintf("%s.mp4", input.PostID))
-	trimmedVideoPath := filepath.Join(tempDir, fmt.Sprintf("%s_trimmed.mp4", input.PostID))
-	finalFileName := fmt.Sprintf("%s_linuxlock.org.%s", input.PostID, input.Format)
-	finalFilePath := filepath.Join(tempDir, finalFileName)
+	req := DownloadRequest{
+		Type:              "download",
+		Profile:           input.Profile,
+		PostID:            input.PostID,
+		Resolution:        input.Resolution,
+		Format:            input.Format,
+		Thread:            input.Thread,
+		Bundle:            input.Bundle,
+		Captions:          input.Captions,
+		AcknowledgeLabels: input.AcknowledgeLabels,
+	}
 
-	// Process the video
-	err = processM3U8(postDetails.Playlist, input.Resolution, input.PostID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error processing video: %v", err), http.StatusInternalServerError)
-		fmt.Printf("Error processing m3u8: %v\n", err)
+	// Wait for a free slot so concurrent downloads don't overload the server
+	ticket := schedule(w, r, req)
+	if ticket == nil {
 		return
 	}
+	defer ticket.Done()
 
-	// Trim the video and convert to the desired format
-	err = trimVideo(videoPath, trimmedVideoPath, "00:00:00.5", input.Format)
+	result, err := req.run(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error trimming video: %v", err), http.StatusInternalServerError)
-		fmt.Printf("Error trimming video: %v\n", err)
+		downloadError(w, r, err)
 		return
 	}
 
-	// Rename the trimmed video file to the final name
-	err = os.Rename(trimmedVideoPath, finalFilePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error renaming video file: %v", err), http.StatusInternalServerError)
-		fmt.Printf("Error renaming video file: %v\n", err)
+	recordDownload(r, result)
+
+	if input.Thread {
+		response := map[string]interface{}{
+			"status":   "success",
+			"message":  "Thread processed successfully",
+			"filename": fileURL(result),
+		}
+		if len(result.Warnings) > 0 {
+			response["warnings"] = result.Warnings
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 		return
 	}
-	fmt.Printf("Trimmed video renamed successfully: %s\n", finalFilePath)
 
 	// Respond with the final video URL
-	response := map[string]string{
+	response := map[string]interface{}{
 		"status":   "success",
 		"message":  "Video processed successfully",
-		"filename": fmt.Sprintf("http://localhost:4000/videos/%s/%s", input.PostID, finalFileName),
+		"filename": fileURL(result),
+		"source":   result.Source,
+	}
+	if result.VerifiedCID != "" {
+		response["cid"] = result.VerifiedCID
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func trimVideo(inputFileName, outputFileName, startTime, format string) error {
-	// Construct the full paths for input and output files
-	inputPath := filepath.Join(inputFileName)
-	outputPath := filepath.Join(outputFileName)
-
-	// Normalize paths for FFmpeg (use forward slashes for compatibility)
-	ffmpegInputPath := filepath.ToSlash(inputPath)
-	ffmpegOutputPath := filepath.ToSlash(outputPath)
-
-	fmt.Printf("Trimming video: inputPath=%s, outputPath=%s, format=%s\n", ffmpegInputPath, ffmpegOutputPath, format)
-
-	// Ensure the input file exists
-	if _, err := os.Stat(ffmpegInputPath); os.IsNotExist(err) {
-		return fmt.Errorf("input file does not exist: %s", ffmpegInputPath)
-	}
-
-	// FFmpeg arguments
-	args := []string{
-		"-y", "-i", ffmpegInputPath, "-ss", startTime,
-	}
-
-	if format == "ts" {
-		// Set compatible codecs for MPEG TS
-		args = append(args, "-c:v", "mpeg2video", "-b:v", "1000k", "-c:a", "aac", "-b:a", "128k", "-strict", "experimental", ffmpegOutputPath)
-	} else if format == "mp4" {
-		// Default codecs for MP4
-		args = append(args, "-c:v", "libx264", "-preset", "fast", "-crf", "23", "-c:a", "aac", "-strict", "experimental", ffmpegOutputPath)
-	} else {
-		return fmt.Errorf("unsupported format: %s", format)
-	}
-
-	// Run FFmpeg command
-	cmd := exec.Command("ffmpeg", args...)
-
-	// Capture FFmpeg's output for debugging
-	var stdOut, stdErr strings.Builder
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	err := cmd.Run()
-	if err != nil {
-		fmt.Printf("FFmpeg stdout: %s\n", stdOut.String())
-		fmt.Printf("FFmpeg stderr: %s\n", stdErr.String())
-		return fmt.Errorf("failed to trim and re-encode video: %v", err)
-	}
-
-	fmt.Printf("FFmpeg stdout: %s\n", stdOut.String())
-	fmt.Printf("Video trimmed and re-encoded successfully: %s\n", ffmpegOutputPath)
-	return nil
+// Serve video files from a "videos" directory
+func serveVideos(w http.ResponseWriter, r *http.Request) {
+	serveFile(w, r, "/videos/", dl.WorkDir())
 }
 
-func processM3U8(playlistURL, userResolution, postID string) error {
-	// Define temporary directory for this post
-	tempDir := filepath.Join("videos", postID)
-	fmt.Println("Ensuring temporary directory for the post:", tempDir)
-
-	// Ensure the post directory exists
-	err := os.MkdirAll(tempDir, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %v", err)
-	}
+// serveArchive serves the posts bulk jobs archived. The archive is public, like /videos/:
+// it only holds public posts, and every job that archives a post links the same file,
+// so it isn't tied to the job's owner or API key.
+func serveArchive(w http.ResponseWriter, r *http.Request) {
+	serveFile(w, r, "/archive/", cfg.Storage.ArchiveDir)
+}
 
-	fmt.Println("Fetching master playlist:", playlistURL)
+// serveFile serves the file under root named by the URL path after prefix as a download.
+func serveFile(w http.ResponseWriter, r *http.Request, prefix, root string) {
+	// Extract the requested file name from the URL path after the prefix
+	requestedPath := strings.TrimPrefix(r.URL.Path, prefix)
+	requestedPath = filepath.ToSlash(requestedPath) // Normalize path separators
+	videoPath := filepath.Join(root, requestedPath) // Build the full file path
 
-	// Fetch the master .m3u8 file
-	resp, err := http.Get(playlistURL)
-	if err != nil {
-		return fmt.Errorf("failed to fetch .m3u8 file: %v", err)
-	}
-	defer resp.Body.Close()
+	slog.DebugContext(r.Context(), "Requested video", "path", requestedPath, "resolved", videoPath)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("master playlist returned status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read .m3u8 file: %v", err)
+	// Check if the file exists, directories aren't listed
+	if info, err := os.Stat(videoPath); err != nil || info.IsDir() {
+		slog.InfoContext(r.Context(), "File not found", "path", videoPath)
+		jsonError(w, errFileNotFound, "File not found")
+		return
 	}
 
-	fmt.Println("Master playlist content:\n", string(body))
-
-	// Parse the .m3u8 file
-	lines := strings.Split(string(body), "\n")
-	fmt.Println("Parsing master playlist for resolution:", userResolution)
+	// Extract the file name to use as the default download name
+	fileName := filepath.Base(videoPath)
 
-	var resolutionURL string
-	baseURL := playlistURL[:strings.LastIndex(playlistURL, "/")+1] // Extract base URL
-	fmt.Println("Base URL for playlist:", baseURL)
+	// Set headers for serving the file
+	contentType := "video/mp4"
+	switch filepath.Ext(fileName) {
+	case ".zip":
+		contentType = "application/zip"
+	case ".mkv":
+		contentType = "video/x-matroska"
+	case ".vtt":
+		contentType = "text/vtt"
+	case ".srt":
+		contentType = "application/x-subrip"
+	case ".jpg":
+		contentType = "image/jpeg"
+	case ".png":
+		contentType = "image/png"
+	case ".webp":
+		contentType = "image/webp"
+	case ".gif":
+		contentType = "image/gif"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
 
-	// Iterate through lines to find the desired resolution and its URL
-	for i := 0; i < len(lines)-1; i++ {
-		line := strings.TrimSpace(lines[i])
-		fmt.Printf("Processing line %d: %s\n", i, line)
+	slog.DebugContext(r.Context(), "Serving video", "path", videoPath, "filename", fileName)
 
-		if strings.Contains(line, "RESOLUTION=") {
-			// Extract resolution dimensions (e.g., "1280x720")
-			resolutionStr := strings.Split(line, "RESOLUTION=")[1]
-			dimension := strings.Split(resolutionStr, ",")[0]
+	// Serve the file directly
+	http.ServeFile(w, r, videoPath)
+}
 
-			// Convert dimensions to user-friendly format (e.g., "720p")
-			height := strings.Split(dimension, "x")[1]
-			mappedResolution := fmt.Sprintf("%sp", height)
+func startCleanupTask() {
+	store := storage.New(dl.WorkDir())
+	go func() {
+		for {
+			time.Sleep(cfg.Storage.CleanupInterval)
 
-			if mappedResolution == userResolution {
-				if i+1 < len(lines) {
-					nextLine := strings.TrimSpace(lines[i+1])
-					if nextLine != "" && !strings.HasPrefix(nextLine, "#") {
-						resolutionURL = nextLine
-						break
-					}
-				}
+			if err := store.Cleanup(cfg.Storage.MaxAge); err != nil {
+				slog.Error("Error cleaning up videos directory", "error", err)
+			}
+			if swept := jobs.Sweep(cfg.Storage.JobTTL); swept > 0 {
+				slog.Info("Forgot finished jobs", "count", swept)
 			}
 		}
-	}
-
-	if resolutionURL == "" {
-		return fmt.Errorf("resolution %s not found in .m3u8 file", userResolution)
-	}
-
-	if !strings.HasPrefix(resolutionURL, "http") {
-		resolutionURL = baseURL + resolutionURL
-	}
-
-	fmt.Println("Full resolution-specific URL:", resolutionURL)
-
-	// Process resolution-specific .m3u8 file
-	err = downloadSegments(resolutionURL, tempDir)
-	if err != nil {
-		return fmt.Errorf("failed to process resolution %s: %v", userResolution, err)
-	}
-
-	return nil
+	}()
 }
 
-func downloadSegments(resolutionURL, tempDir string) error {
-	fmt.Println("Fetching resolution-specific .m3u8 file:", resolutionURL)
-
-	// Fetch the resolution-specific .m3u8 file
-	resp, err := http.Get(resolutionURL)
-	if err != nil {
-		return fmt.Errorf("failed to fetch resolution-specific .m3u8 file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("resolution-specific .m3u8 returned status code: %d", resp.StatusCode)
+func main() {
+	// Settings come from defaults, a config file, the environment and flags, in that order
+	loaded, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read resolution-specific .m3u8 file: %v", err)
-	}
-
-	lines := strings.Split(string(body), "\n")
-	baseURL := resolutionURL[:strings.LastIndex(resolutionURL, "/")+1]
-	fmt.Println("Base URL for segments:", baseURL)
-
-	var segmentFiles []string
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.HasPrefix(line, "#") && line != "" {
-			if !strings.HasPrefix(line, "http") {
-				line = baseURL + line
-			}
-
-			segmentPath := filepath.Join(tempDir, fmt.Sprintf("segment-%d.ts", i))
-			segmentFiles = append(segmentFiles, segmentPath)
-
-			fmt.Printf("Downloading segment %d: %s\n", i, line)
-
-			resp, err := http.Get(line)
-			if err != nil {
-				return fmt.Errorf("failed to download segment %d: %v", i, err)
-			}
-			defer resp.Body.Close()
-
-			out, err := os.Create(segmentPath)
-			if err != nil {
-				return fmt.Errorf("failed to create segment file: %v", err)
-			}
-			defer out.Close()
-
-			_, err = io.Copy(out, resp.Body)
-			if err != nil {
-				return fmt.Errorf("failed to save segment %d: %v", i, err)
-			}
-		}
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		os.Exit(2)
 	}
+	cfg = loaded
 
-	// Combine segments into MP4
-	err = combineSegments(segmentFiles, tempDir, filepath.Base(tempDir))
+	logger, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
 	if err != nil {
-		return fmt.Errorf("failed to combine segments: %v", err)
+		fmt.Fprintln(os.Stderr, "Error configuring logging:", err)
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
 
-	return nil
-}
-
-func combineSegments(segmentFiles []string, postDir, postID string) error {
-	// Path to `segments.txt`
-	segmentsTxtPath := filepath.Join(postDir, "segments.txt")
-
-	// Create the `segments.txt` file
-	listFile, err := os.Create(segmentsTxtPath)
-	if err != nil {
-		return fmt.Errorf("failed to create segments.txt file: %v", err)
+	// Export spans to an OTLP/HTTP collector such as http://localhost:4318
+	if cfg.Tracing.Endpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), cfg.Tracing.Endpoint, cfg.Tracing.ServiceName)
+		if err != nil {
+			slog.Error("Error configuring tracing", "error", err)
+			os.Exit(1)
+		}
+		defer shutdown(context.Background())
+		slog.Info("Exporting traces", "endpoint", cfg.Tracing.Endpoint, "service", cfg.Tracing.ServiceName)
 	}
-	defer listFile.Close()
 
-	// Write segment file entries
-	for _, segment := range segmentFiles {
-		relativePath := filepath.Base(segment)
-		listFile.WriteString(fmt.Sprintf("file '%s'\n", relativePath))
+	// Outgoing requests carry the trace in their traceparent header
+	opts := []downloader.Option{
+		downloader.WithWorkDir(cfg.Storage.WorkDir),
+		downloader.WithTransport(tracing.Transport(http.DefaultTransport)),
 	}
 
-	// Output MP4 file path
-	outputFile := filepath.Join(postDir, fmt.Sprintf("%s.mp4", postID))
-
-	// Normalize paths for FFmpeg
-	ffmpegSegmentsPath := filepath.ToSlash(segmentsTxtPath)
-	ffmpegOutputPath := filepath.ToSlash(outputFile)
-
-	// Ensure the directory exists
-	if _, err := os.Stat(postDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(postDir, 0755); err != nil {
-			return fmt.Errorf("failed to create output directory: %v", err)
+	// Load a custom label policy
+	if cfg.Bluesky.LabelPolicyFile != "" {
+		policy, err := bsky.LoadLabelPolicy(cfg.Bluesky.LabelPolicyFile, cfg.Bluesky.Authenticated())
+		if err != nil {
+			slog.Error("Error loading label policy", "error", err)
+			os.Exit(1)
 		}
+		opts = append(opts, downloader.WithLabelPolicy(policy))
+		slog.Info("Loaded label policy", "path", cfg.Bluesky.LabelPolicyFile)
 	}
 
-	// Construct the FFmpeg command
-	cmd := exec.Command("ffmpeg", "-f", "concat", "-safe", "0", "-i", ffmpegSegmentsPath, "-c", "copy", ffmpegOutputPath)
-
-	// Capture FFmpeg's standard output and error for debugging
-	var stdOut, stdErr strings.Builder
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	// Log paths and FFmpeg command
-	fmt.Printf("segments.txt path: %s\n", ffmpegSegmentsPath)
-	fmt.Printf("Output video path: %s\n", ffmpegOutputPath)
-	fmt.Printf("Running FFmpeg command: %s\n", strings.Join(cmd.Args, " "))
-
-	// Run the FFmpeg command
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("FFmpeg stdout: %s\n", stdOut.String())
-		fmt.Printf("FFmpeg stderr: %s\n", stdErr.String())
-		return fmt.Errorf("failed to run FFmpeg: %v", err)
+	// Replace the hosts external media may be fetched from
+	if len(cfg.Bluesky.ExternalMediaHosts) > 0 {
+		opts = append(opts, downloader.WithExternalMediaHosts(cfg.Bluesky.ExternalMediaHosts))
 	}
 
-	fmt.Printf("FFmpeg stdout: %s\n", stdOut.String())
-	fmt.Printf("Video combined successfully: %s\n", outputFile)
-
-	return nil
-}
-
-// Serve video files from a "videos" directory
-func serveVideos(w http.ResponseWriter, r *http.Request) {
-	// Extract the requested file name from the URL path after "/videos/"
-	requestedPath := strings.TrimPrefix(r.URL.Path, "/videos/")
-	requestedPath = filepath.ToSlash(requestedPath)     // Normalize path separators
-	videoPath := filepath.Join("videos", requestedPath) // Build the full file path
-
-	// Debug logs
-	fmt.Printf("Requested video: %s\n", requestedPath)
-	fmt.Printf("Resolved video path: %s\n", videoPath)
-
-	// Check if the file exists
-	if _, err := os.Stat(videoPath); os.IsNotExist(err) {
-		fmt.Printf("Error: File not found at %s\n", videoPath)
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+	// Point AppView queries at a different service or authenticate with an app password
+	if cfg.Bluesky.AppViewURL != "" {
+		opts = append(opts, downloader.WithAppViewURL(cfg.Bluesky.AppViewURL))
 	}
-
-	fmt.Printf("File found: %s\n", videoPath)
-
-	// Extract the file name to use as the default download name
-	fileName := filepath.Base(videoPath)
-
-	// Set headers for serving the file
-	w.Header().Set("Content-Type", "video/mp4")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
-
-	fmt.Printf("Serving video: %s with Content-Disposition: attachment; filename=%s\n", videoPath, fileName)
-
-	// Serve the file directly
-	http.ServeFile(w, r, videoPath)
-}
-
-func truncateTitle(title string) string {
-	if len(title) > 64 {
-		return title[:61] + "..."
+	if cfg.Bluesky.AppViewDID != "" {
+		opts = append(opts, downloader.WithAppViewDID(cfg.Bluesky.AppViewDID))
 	}
-	return title
-}
-
-func extractPostDetails(url string) (string, string, error) {
-	re := regexp.MustCompile(`/profile/([^/]+)/post/([^/]+)`)
-	matches := re.FindStringSubmatch(url)
-	if len(matches) < 3 {
-		return "", "", fmt.Errorf("invalid URL format")
+	if cfg.Bluesky.PDSURL != "" {
+		opts = append(opts, downloader.WithPDSURL(cfg.Bluesky.PDSURL))
 	}
-	return matches[1], matches[2], nil
-}
-
-// fetchPostMetadata fetches the metadata for the given profile and postID.
-func FetchPostMetadata(profile, postID string) (*PostDetails, error) {
-	apiURL := fmt.Sprintf("https://public.api.bsky.app/xrpc/app.bsky.feed.getPostThread?uri=at://%s/app.bsky.feed.post/%s&depth=0", profile, postID)
-	fmt.Println("Fetching metadata from URL:", apiURL)
+	opts = append(opts, downloader.WithAppPassword(cfg.Bluesky.Identifier, cfg.Bluesky.AppPassword))
 
-	// Make the API request
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch metadata: %v", err)
-	}
-	defer resp.Body.Close()
+	// Limit how much work runs at once: downloads admitted from the queue, segment
+	// fetches and transcodes are capped separately
+	queue = scheduler.New(cfg.Limits.MaxActiveDownloads, cfg.Limits.MaxQueuedDownloads)
+	opts = append(opts,
+		downloader.WithNetworkLimiter(scheduler.NewLimiter(cfg.Limits.NetworkConcurrency)),
+		downloader.WithCPULimiter(scheduler.NewLimiter(cfg.Limits.TranscodeConcurrency)),
+	)
 
-	// Check for non-200 status codes
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status: %v, response: %s", resp.StatusCode, string(body))
-	}
+	// Cap what a single request may cost
+	opts = append(opts,
+		downloader.WithMaxDuration(time.Duration(cfg.Limits.MaxVideoSeconds)*time.Second),
+		downloader.WithMaxOutputSize(int64(cfg.Limits.MaxOutputMB)<<20),
+		downloader.WithMaxBlobSize(int64(cfg.Limits.MaxBlobMB)<<20),
+	)
 
-	// Parse the response body
-	body, err := io.ReadAll(resp.Body)
+	// Rate limit clients, believing forwarding headers only from our own proxies
+	processLimiter = ratelimit.New(cfg.RateLimit.ProcessPerMinute, cfg.RateLimit.ProcessBurst)
+	downloadLimiter = ratelimit.New(cfg.RateLimit.DownloadPerMinute, cfg.RateLimit.DownloadBurst)
+	networks, err := parseTrustedProxies(cfg.Server.TrustedProxies)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		slog.Error("Error reading trusted proxies", "error", err)
+		os.Exit(1)
 	}
-	fmt.Println("Raw API response:", string(body))
+	trustedProxies = networks
 
-	var response map[string]interface{}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
-	}
-
-	// Extract post details
-	postDetails := &PostDetails{
-		Cid:         safeExtract(response, []string{"thread", "post", "embed", "cid"}).(string),
-		Playlist:    safeExtract(response, []string{"thread", "post", "embed", "playlist"}).(string),
-		Thumbnail:   safeExtract(response, []string{"thread", "post", "embed", "thumbnail"}).(string),
-		Title:       safeExtract(response, []string{"thread", "post", "record", "text"}).(string),
-		LikeCount:   int(safeExtract(response, []string{"thread", "post", "likeCount"}).(float64)),
-		ReplyCount:  int(safeExtract(response, []string{"thread", "post", "replyCount"}).(float64)),
-		RepostCount: int(safeExtract(response, []string{"thread", "post", "repostCount"}).(float64)),
-		AspectRatio: &AspectRatio{
-			Height: int(safeExtract(response, []string{"thread", "post", "embed", "aspectRatio", "height"}).(float64)),
-			Width:  int(safeExtract(response, []string{"thread", "post", "embed", "aspectRatio", "width"}).(float64)),
-		},
-	}
-
-	return postDetails, nil
-}
-
-// Recursive function to extract nested values
-func safeExtract(m map[string]interface{}, keys []string) interface{} {
-	current := m
-	for i, key := range keys {
-		value, exists := current[key]
-		if !exists {
-			fmt.Printf("Key path not found: %v (missing key: %s)\n", keys[:i+1], key)
-			return nil
-		}
-		if i == len(keys)-1 {
-			return value
+	// Give partner integrations API keys with their own quotas
+	if cfg.APIKeys.File != "" {
+		usageFile := cfg.APIKeys.UsageFile
+		if usageFile == "" {
+			usageFile = cfg.APIKeys.File + ".usage"
 		}
-		if nextMap, ok := value.(map[string]interface{}); ok {
-			current = nextMap
-		} else {
-			fmt.Printf("Key path %v is not a map at %s\n", keys[:i+1], key)
-			return nil
+		keys, err := apikeys.Load(cfg.APIKeys.File, usageFile)
+		if err != nil {
+			slog.Error("Error loading API keys", "error", err)
+			os.Exit(1)
 		}
+		apiKeys = keys
+		apiKeysRequired = cfg.APIKeys.Required
+		startUsageFlush()
+		slog.Info("Loaded API keys", "count", len(keys.List()), "path", cfg.APIKeys.File)
+	} else {
+		// Only admin keys can be trusted with the key list and the configuration
+		slog.Info("API keys are disabled, so are the /admin endpoints")
 	}
-	return nil
-}
 
-func startCleanupTask() {
-	go func() {
-		for {
-			time.Sleep(15 * time.Minute) // Cleanup interval
-
-			files, err := os.ReadDir("videos")
-			if err != nil {
-				fmt.Println("Error reading videos directory:", err)
-				continue
-			}
+	dl = downloader.New(opts...)
+	if dl.Authenticated() {
+		slog.Info("Using authenticated session", "identifier", cfg.Bluesky.Identifier)
+	}
+	registerMetrics(storage.New(dl.WorkDir()))
 
-			for _, file := range files {
-				if file.IsDir() {
-					dirPath := filepath.Join("videos", file.Name())
-					info, err := os.Stat(dirPath)
-					if err != nil {
-						fmt.Println("Error getting file info:", err)
-						continue
-					}
-
-					if time.Since(info.ModTime()) > 30*time.Minute {
-						fmt.Println("Deleting expired folder:", dirPath)
-						os.RemoveAll(dirPath)
-					}
-				}
-			}
-		}
-	}()
-}
+	// Pick up the bulk jobs the last run didn't finish
+	if err := resumeJobs(cfg.Storage.JobsFile); err != nil {
+		slog.Error("Error resuming jobs", "error", err)
+		os.Exit(1)
+	}
 
-func main() {
 	r := mux.NewRouter()
-	r.HandleFunc("/process", process).Methods("POST")
-	r.HandleFunc("/download", download).Methods("POST")
+	r.Use(instrumented, apiKeyAuth)
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.HandleFunc("/process", rateLimited(processLimiter, process)).Methods("POST")
+	r.HandleFunc("/download", rateLimited(downloadLimiter, download)).Methods("POST")
+	r.HandleFunc("/captions", rateLimited(downloadLimiter, captionsHandler)).Methods("POST")
+	r.HandleFunc("/images", rateLimited(downloadLimiter, downloadImagesHandler)).Methods("POST")
+	r.HandleFunc("/external", rateLimited(downloadLimiter, downloadExternalHandler)).Methods("POST")
+	r.HandleFunc("/bulk", rateLimited(downloadLimiter, bulk)).Methods("POST")
+	r.HandleFunc("/jobs/{id}", getJob).Methods("GET")
+	r.HandleFunc("/jobs/{id}", cancelJob).Methods("DELETE")
+	r.HandleFunc("/admin/keys", requireAdmin(listKeys)).Methods("GET")
+	r.HandleFunc("/admin/keys/{id}/rotate", requireAdmin(rotateKey)).Methods("POST")
+	r.HandleFunc("/admin/config", requireAdmin(showConfig)).Methods("GET") // Like every /admin route, 404 unless API keys are configured
 	r.PathPrefix("/videos/").HandlerFunc(serveVideos).Methods("GET")
+	r.PathPrefix("/archive/").HandlerFunc(serveArchive).Methods("GET")
 	r.HandleFunc("/test", TestHandler).Methods("GET")
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", readyz).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
 
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins: []string{
-			"http://localhost:3000",
-			"http:linuxlock.org",
-			"https:linuxlock.org",
-			"http:linuxlock.org/api",
-			"https:linuxlock.org/api"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedOrigins:   cfg.Server.CORSOrigins,
+		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID"},
+		ExposedHeaders:   []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Request-ID"},
 		AllowCredentials: true,
 	}).Handler(r)
 
-	fmt.Println("Server is running on port 4000")
+	// Requests run on a context that is cancelled if they outlast the shutdown timeout
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:        cfg.Server.Addr,
+		Handler:     tracing.Handler(requestID(corsHandler), cfg.Tracing.ServiceName),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
 
 	// Start the cleanup task
 	startCleanupTask()
 
-	http.ListenAndServe(":4000", corsHandler)
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	slog.Info("Server is running", "addr", cfg.Server.Addr)
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	case <-signals.Done():
+	}
+
+	// A second signal kills the server right away
+	stop()
+	shutdown(srv, cancelRequests)
 }
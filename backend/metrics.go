@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/storage"
+	"github.com/gorilla/mux"
+)
+
+// instrumented counts requests and observes their latency per route. The route is
+// the path template it matched, so IDs in the path don't blow up the label set.
+func instrumented(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// storageRefresh is how often storage_used_bytes walks the work directory again.
+const storageRefresh = time.Minute
+
+// registerMetrics adds the gauges read from the server's own state.
+func registerMetrics(store *storage.Store) {
+	metrics.GaugeFunc("queue_depth", "Downloads waiting for a slot.", func() float64 {
+		_, queued := queue.Stats()
+		return float64(queued)
+	})
+	metrics.GaugeFunc("downloads_active", "Downloads currently running.", func() float64 {
+		active, _ := queue.Stats()
+		return float64(active)
+	})
+
+	// Walking the work directory is too slow to do on every scrape
+	var used atomic.Int64
+	go func() {
+		for {
+			used.Store(store.Usage())
+			time.Sleep(storageRefresh)
+		}
+	}()
+	metrics.GaugeFunc("storage_used_bytes", "Size of the files in the work directory, refreshed every minute.", func() float64 {
+		return float64(used.Load())
+	})
+}
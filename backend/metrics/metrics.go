@@ -0,0 +1,103 @@
+// Package metrics defines the Prometheus metrics the download pipeline reports.
+// The collectors are registered with the default registry, which Handler serves.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bskydl"
+
+var (
+	// HTTPRequests counts handled requests by route template, method and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes how long requests take by route template and method.
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and method.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15), // 10ms to ~3m
+	}, []string{"route", "method"})
+
+	// StageDuration observes how long each stage of the pipeline takes.
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Time taken by download pipeline stages (metadata, playlist, segments, original, combine, transcode).",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14), // 50ms to ~7m
+	}, []string{"stage"})
+
+	// CDNBytes counts bytes downloaded from the CDN and PDSes by kind of media.
+	CDNBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cdn_downloaded_bytes_total",
+		Help:      "Bytes downloaded from the CDN and PDSes, by source (segment, blob, image, external).",
+	}, []string{"source"})
+
+	// SegmentRetries counts HLS segment downloads that were retried.
+	SegmentRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "segment_retries_total",
+		Help:      "HLS segment downloads retried after a failure.",
+	})
+
+	// CacheLookups counts lookups in the identity caches. The hit ratio is
+	// hits / (hits + misses) of the same cache.
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Identity cache lookups, by cache (handle, did_document) and result (hit, miss).",
+	}, []string{"cache", "result"})
+
+	// ActiveFFmpeg is the number of ffmpeg processes running.
+	ActiveFFmpeg = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_processes_active",
+		Help:      "ffmpeg processes currently running.",
+	})
+
+	// CleanupEvictions counts post directories removed by the cleanup task.
+	CleanupEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cleanup_evictions_total",
+		Help:      "Expired post directories removed from the work directory.",
+	})
+)
+
+// ObserveStage records how long a pipeline stage took since start.
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// CacheLookup records a hit or a miss in the named cache.
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape.
+func GaugeFunc(name, help string, fn func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/ratelimit"
+)
+
+// Rate limits per client IP. main replaces them once the configuration has been read.
+var (
+	processLimiter  = ratelimit.New(30, 10)
+	downloadLimiter = ratelimit.New(6, 3)
+)
+
+// trustedProxies are the networks whose X-Forwarded-For and X-Real-IP headers are believed.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies reads a list of IPs and CIDR ranges.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientID returns the IP a request comes from. Forwarding headers are only honored
+// when the connection comes from a trusted proxy, and X-Forwarded-For is read from the
+// right so a client can't pick its own address by sending the header itself.
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			if !isTrustedProxy(hop) || i == 0 {
+				return hop.String()
+			}
+		}
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return host
+}
+
+// rateLimited only lets a request through to next if the client has a token left in limiter.
+// Requests made with an API key are subject to the key's quotas instead.
+func rateLimited(limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestKey(r) != nil {
+			next(w, r)
+			return
+		}
+
+		client := clientID(r)
+		result := limiter.Allow(client)
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+		if !result.Allowed {
+			retryAfter := seconds(result.RetryAfter)
+			slog.WarnContext(r.Context(), "Rate limit exceeded", "client", client, "path", r.URL.Path)
+
+			retryError(w, errRateLimited, "Too many requests, please slow down", retryAfter)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// seconds rounds a duration up to whole seconds for the rate limit headers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,101 @@
+// Package ratelimit keeps a token bucket per client so each one can only make a
+// limited number of requests in a given time.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter hands out tokens from a bucket per key that refills at a steady rate.
+// It is safe for concurrent use.
+type Limiter struct {
+	rate  float64 // Tokens added per second
+	burst float64 // Size of the bucket
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result describes the state of a client's bucket after a request.
+type Result struct {
+	Allowed    bool
+	Limit      int           // Size of the bucket
+	Remaining  int           // Tokens left after this request
+	RetryAfter time.Duration // Until the next token, when the request wasn't allowed
+	Reset      time.Duration // Until the bucket is full again
+}
+
+// New returns a limiter that allows perMinute requests a minute per key, with bursts of up to burst.
+func New(perMinute, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket if there is one.
+func (l *Limiter) Allow(key string) Result {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	result := Result{Limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.until(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.until(l.burst - b.tokens)
+	return result
+}
+
+// until returns how long it takes to refill the given number of tokens.
+func (l *Limiter) until(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// prune forgets buckets that have refilled completely, at most once a minute. The caller must hold the lock.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
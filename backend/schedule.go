@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/Rudra644/bluesky_downloader/apikeys"
+	"github.com/Rudra644/bluesky_downloader/downloader"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+)
+
+// queue admits downloads, taking turns between clients. main replaces it once the configuration has been read.
+var queue = scheduler.New(4, 32)
+
+// DownloadRequest is a download made through /download, /images or /external. It is
+// saved with the job a queued request is handed to, so the job can be resumed.
+type DownloadRequest struct {
+	Type       string   `json:"type"` // "download", "images" or "external"
+	Profile    string   `json:"profile"`
+	PostID     string   `json:"postID"`
+	Resolution string   `json:"resolution,omitempty"`
+	Format     string   `json:"format,omitempty"`
+	Thread     bool     `json:"thread,omitempty"`
+	Bundle     string   `json:"bundle,omitempty"`
+	Index      *int     `json:"index,omitempty"`
+	Captions   []string `json:"captions,omitempty"`
+
+	AcknowledgeLabels bool `json:"acknowledgeLabels,omitempty"`
+}
+
+// run runs the download.
+func (req DownloadRequest) run(ctx context.Context) (*downloader.Result, error) {
+	switch {
+	case req.Type == "images":
+		return dl.DownloadImages(ctx, downloader.ImageRequest{
+			Profile:           req.Profile,
+			PostID:            req.PostID,
+			Index:             req.Index,
+			Format:            req.Format,
+			AcknowledgeLabels: req.AcknowledgeLabels,
+		})
+	case req.Type == "external":
+		return dl.DownloadExternal(ctx, downloader.ExternalRequest{
+			Profile:           req.Profile,
+			PostID:            req.PostID,
+			Format:            req.Format,
+			AcknowledgeLabels: req.AcknowledgeLabels,
+		})
+	case req.Thread:
+		return dl.DownloadThread(ctx, downloader.ThreadRequest{
+			Profile:           req.Profile,
+			PostID:            req.PostID,
+			Resolution:        req.Resolution,
+			Format:            req.Format,
+			Bundle:            req.Bundle,
+			AcknowledgeLabels: req.AcknowledgeLabels,
+		})
+	default:
+		return dl.Download(ctx, downloader.Request{
+			Profile:           req.Profile,
+			PostID:            req.PostID,
+			Resolution:        req.Resolution,
+			Format:            req.Format,
+			Captions:          req.Captions,
+			AcknowledgeLabels: req.AcknowledgeLabels,
+		})
+	}
+}
+
+// schedule waits for the request's turn in the download queue. It returns nil if the
+// queue is full, in which case it has responded with 503, or if the client went away
+// while waiting. A request queued further back than limits.queue_async_after doesn't
+// wait either: it is handed to a background job that runs req when its turn comes,
+// and answered with 202 and the job's ID so the client can follow its queue position.
+// Otherwise the caller must call Done on the ticket when it's finished.
+func schedule(w http.ResponseWriter, r *http.Request, req DownloadRequest) *scheduler.Ticket {
+	ticket, err := queue.Submit(clientID(r))
+	if err != nil {
+		retryAfter := int(math.Ceil(queue.RetryAfter().Seconds()))
+		retryError(w, errServerBusy, "Server is busy, please try again later", retryAfter)
+		slog.WarnContext(r.Context(), "Download queue is full", "client", clientID(r), "retryAfter", retryAfter)
+		return nil
+	}
+
+	if after := cfg.Limits.QueueAsyncAfter; after > 0 && ticket.Position() > after {
+		deferDownload(w, r, ticket, req)
+		return nil
+	}
+
+	if err := ticket.Wait(r.Context()); err != nil {
+		slog.InfoContext(r.Context(), "Client went away while queued", "client", clientID(r))
+		return nil
+	}
+	return ticket
+}
+
+// deferDownload turns a queued request into a background job and responds with its ID.
+func deferDownload(w http.ResponseWriter, r *http.Request, ticket *scheduler.Ticket, req DownloadRequest) {
+	if jobs.Stopping() {
+		ticket.Done()
+		jsonError(w, errShuttingDown, "Server is shutting down, please try again later")
+		return
+	}
+
+	// The job outlives the request, so it runs on the job's own context
+	job, ctx := jobs.Create(r.Context(), req.Type, jobOwner(r))
+	jobs.Update(job.ID, func(job *Job) {
+		job.Total = 1
+		job.Items = []JobItem{{Profile: req.Profile, PostID: req.PostID, Status: JobQueued}}
+	})
+	jobs.SetTicket(job.ID, ticket)
+
+	position := ticket.Position()
+	slog.InfoContext(ctx, "Queued download as a job", "type", req.Type, "queuePosition", position)
+
+	key := requestKey(r)
+	saved := savedJob{Client: clientID(r), Download: &req}
+	if key != nil {
+		saved.KeyID = key.ID
+	}
+	jobs.SetResume(job.ID, saved)
+	jobs.Run(func() { runDeferredDownload(ctx, job.ID, saved.Client, ticket, key, req) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", strings.TrimSuffix(cfg.Server.BaseURL, "/")+"/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobID":         job.ID,
+		"status":        JobQueued,
+		"queuePosition": position,
+	})
+}
+
+// runDeferredDownload waits for the job's turn and runs its download. Downloads count
+// against key, if set. A job resumed after a restart has no ticket yet and queues again.
+// When the server stops, a job still in the queue gives up its place and is saved to be
+// resumed, rather than holding up the shutdown.
+func runDeferredDownload(ctx context.Context, jobID, client string, ticket *scheduler.Ticket, key *apikeys.Key, req DownloadRequest) {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := jobs.Stopped()
+	go func() {
+		select {
+		case <-stopped:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+
+	var err error
+	if ticket == nil {
+		ticket, err = waitTurn(waitCtx, jobID, client)
+	} else {
+		err = ticket.Wait(waitCtx)
+		jobs.SetTicket(jobID, nil)
+	}
+	if err != nil {
+		stopItems(ctx, jobID, 0)
+		return
+	}
+	defer ticket.Done()
+
+	if jobs.Stopping() {
+		stopItems(ctx, jobID, 0)
+		return
+	}
+	jobs.Update(jobID, func(job *Job) {
+		job.Status = JobRunning
+		job.Items[0].Status = JobRunning
+	})
+
+	result, err := req.run(ctx)
+	if ctx.Err() != nil {
+		// The downloader has already removed the partial files
+		stopItems(ctx, jobID, 0)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Queued download failed", "error", err)
+		jobs.Update(jobID, func(job *Job) {
+			job.Items[0].fail(err)
+			job.Failed++
+		})
+		jobs.Finish(jobID, JobFailed, err)
+		return
+	}
+
+	recordUsage(key, result.FilePath)
+	jobs.Update(jobID, func(job *Job) {
+		job.Items[0].Status = JobCompleted
+		job.Items[0].Filename = fileURL(result)
+		job.Items[0].CID = result.VerifiedCID
+		job.Items[0].Warnings = result.Warnings
+		job.Completed++
+	})
+	jobs.Finish(jobID, JobCompleted, nil)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/config"
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+)
+
+// busyQueue gives the test its own configuration, job store and a queue with one
+// download running and another waiting, so another client's request is queued second
+// and, with queue_async_after at 1, deferred.
+func busyQueue(t *testing.T) {
+	testJobs(t)
+	oldCfg, oldQueue := cfg, queue
+	t.Cleanup(func() { cfg, queue = oldCfg, oldQueue })
+
+	cfg = config.Default()
+	cfg.Limits.QueueAsyncAfter = 1
+	queue = scheduler.New(1, 8)
+
+	running, _ := queue.Submit("other")
+	waiting, _ := queue.Submit("other")
+	t.Cleanup(func() {
+		waiting.Done()
+		running.Done()
+	})
+}
+
+// testJobs gives the test a job store of its own.
+func testJobs(t *testing.T) {
+	old := jobs
+	t.Cleanup(func() { jobs = old })
+	jobs = &JobStore{
+		jobs:    make(map[string]*Job),
+		cancels: make(map[string]context.CancelFunc),
+		tickets: make(map[string]*scheduler.Ticket),
+		resume:  make(map[string]savedJob),
+		stopped: make(chan struct{}),
+	}
+}
+
+// deferred sends a download through schedule and returns the ID of the job it was handed to.
+func deferred(t *testing.T) string {
+	r := httptest.NewRequest(http.MethodPost, "/download", nil)
+	w := httptest.NewRecorder()
+	req := DownloadRequest{Type: "download", Profile: "alice.test", PostID: "3kabc"}
+	if ticket := schedule(w, r, req); ticket != nil {
+		ticket.Done()
+		t.Fatal("schedule admitted the download instead of deferring it")
+	}
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+
+	var body struct {
+		JobID         string `json:"jobID"`
+		QueuePosition int    `json:"queuePosition"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.QueuePosition != 2 {
+		t.Errorf("queuePosition = %d, want 2", body.QueuePosition)
+	}
+	if !strings.HasSuffix(w.Header().Get("Location"), "/jobs/"+body.JobID) {
+		t.Errorf("Location = %q, want the job's URL", w.Header().Get("Location"))
+	}
+	return body.JobID
+}
+
+func waitJobs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := jobs.Wait(ctx); err != nil {
+		t.Fatal("deferred download didn't return")
+	}
+}
+
+func TestScheduleDefersFarBack(t *testing.T) {
+	busyQueue(t)
+	id := deferred(t)
+
+	job, ok := jobs.Get(id)
+	if !ok || job.Status != JobQueued || job.Total != 1 {
+		t.Fatalf("job = %+v, want a queued job with one item", job)
+	}
+
+	jobs.Cancel(id)
+	waitJobs(t)
+	if job, _ := jobs.Get(id); job.Items[0].Status != JobCancelled {
+		t.Errorf("item status = %q, want cancelled", job.Items[0].Status)
+	}
+	if _, queued := queue.Stats(); queued != 1 {
+		t.Errorf("%d downloads queued, want 1 as the cancelled one left the queue", queued)
+	}
+}
+
+func TestDeferredDownloadIsSavedOnShutdown(t *testing.T) {
+	busyQueue(t)
+	id := deferred(t)
+
+	jobs.Stop()
+	waitJobs(t)
+
+	saved := jobs.Unfinished()
+	if len(saved) != 1 || saved[0].Job.ID != id || saved[0].Download == nil {
+		t.Fatalf("unfinished jobs = %+v, want the deferred download", saved)
+	}
+	if saved[0].Job.Status != JobQueued || saved[0].Download.PostID != "3kabc" {
+		t.Errorf("saved job = %+v, want it queued with its request", saved[0])
+	}
+
+	// No new downloads are deferred once the server is stopping
+	w := httptest.NewRecorder()
+	if ticket := schedule(w, httptest.NewRequest(http.MethodPost, "/download", nil), DownloadRequest{Type: "download"}); ticket != nil || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("schedule while stopping responded %d, want 503", w.Code)
+	}
+}
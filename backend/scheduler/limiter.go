@@ -0,0 +1,47 @@
+package scheduler
+
+import "context"
+
+// Limiter caps how many operations of one kind run at once across all downloads.
+// A nil *Limiter doesn't limit anything.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter returns a limiter that lets n operations run at once.
+func NewLimiter(n int) *Limiter {
+	if n < 1 {
+		n = 1
+	}
+	return &Limiter{slots: make(chan struct{}, n)}
+}
+
+// Acquire blocks until a slot is free or ctx is cancelled. Every successful
+// Acquire must be followed by a Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by Acquire.
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// InUse returns how many slots are taken.
+func (l *Limiter) InUse() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
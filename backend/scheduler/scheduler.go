@@ -0,0 +1,292 @@
+// Package scheduler decides when downloads run. A Scheduler admits jobs through a
+// bounded queue, taking turns between clients so one heavy user can't starve the
+// rest, and Limiters cap the network and CPU bound work those jobs do.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a job is submitted while the queue is at capacity.
+var ErrQueueFull = errors.New("queue is full")
+
+// Scheduler runs a limited number of jobs at once and queues a limited number more.
+type Scheduler struct {
+	mu        sync.Mutex
+	maxActive int
+	maxQueued int
+	active    int
+	queued    int
+	waiting   map[string][]*Ticket // Queued tickets per client, oldest first
+	clients   []string             // Clients with queued tickets, in round-robin order
+	next      int                  // Index into clients of the next client to admit from
+	room      chan struct{}        // Closed and replaced whenever a queued ticket leaves the queue
+	avgRun    time.Duration        // Moving average of how long admitted jobs run
+}
+
+// Ticket is a job's place in the queue.
+type Ticket struct {
+	s        *Scheduler
+	client   string
+	admitted chan struct{} // Closed once the job may run
+	start    time.Time
+	once     sync.Once
+}
+
+// New returns a scheduler that runs maxActive jobs at once and queues up to maxQueued more.
+func New(maxActive, maxQueued int) *Scheduler {
+	if maxActive < 1 {
+		maxActive = 1
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	return &Scheduler{
+		maxActive: maxActive,
+		maxQueued: maxQueued,
+		waiting:   make(map[string][]*Ticket),
+		room:      make(chan struct{}),
+	}
+}
+
+// Submit queues a job for client, failing with ErrQueueFull if there is no room.
+func (s *Scheduler) Submit(client string) (*Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.full() {
+		return nil, ErrQueueFull
+	}
+	return s.enqueue(client), nil
+}
+
+// SubmitWait queues a job for client, waiting for room in the queue if it is full.
+// Background jobs use it so they don't fail just because the server is busy.
+func (s *Scheduler) SubmitWait(ctx context.Context, client string) (*Ticket, error) {
+	for {
+		s.mu.Lock()
+		if !s.full() {
+			t := s.enqueue(client)
+			s.mu.Unlock()
+			return t, nil
+		}
+		room := s.room
+		s.mu.Unlock()
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// RetryAfter estimates how long a client turned away with ErrQueueFull should wait.
+func (s *Scheduler) RetryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avg := s.avgRun
+	if avg == 0 {
+		avg = 10 * time.Second
+	}
+	// Roughly how long until the queue has drained by one slot
+	wait := avg / time.Duration(s.maxActive)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// Saturated reports whether every slot is taken and the queue is full, so Submit would fail.
+func (s *Scheduler) Saturated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.full()
+}
+
+// full reports whether there is no room for another job. The caller must hold the lock.
+func (s *Scheduler) full() bool {
+	return s.queued >= s.maxQueued && s.active >= s.maxActive
+}
+
+// Stats returns how many jobs are running and how many are queued.
+func (s *Scheduler) Stats() (active, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, s.queued
+}
+
+// enqueue adds a ticket for client and admits whatever can run. The caller must hold the lock.
+func (s *Scheduler) enqueue(client string) *Ticket {
+	t := &Ticket{s: s, client: client, admitted: make(chan struct{})}
+	if len(s.waiting[client]) == 0 {
+		// New clients join the end of the round, behind everyone already waiting
+		s.clients = append(s.clients, client)
+	}
+	s.waiting[client] = append(s.waiting[client], t)
+	s.queued++
+	s.dispatch()
+	return t
+}
+
+// dispatch admits queued tickets while there are free slots, one client at a time.
+// The caller must hold the lock.
+func (s *Scheduler) dispatch() {
+	for s.active < s.maxActive && len(s.clients) > 0 {
+		if s.next >= len(s.clients) {
+			s.next = 0
+		}
+		client := s.clients[s.next]
+		t := s.waiting[client][0]
+		s.waiting[client] = s.waiting[client][1:]
+
+		if len(s.waiting[client]) == 0 {
+			// Dropping the client moves the next one into its index
+			delete(s.waiting, client)
+			s.clients = append(s.clients[:s.next], s.clients[s.next+1:]...)
+		} else {
+			s.next++
+		}
+
+		s.queued--
+		s.active++
+		t.start = time.Now()
+		close(t.admitted)
+		s.signalRoom()
+	}
+}
+
+// remove takes a ticket that hasn't been admitted out of the queue. The caller must hold the lock.
+func (s *Scheduler) remove(t *Ticket) {
+	queue := s.waiting[t.client]
+	for i, queued := range queue {
+		if queued != t {
+			continue
+		}
+		s.waiting[t.client] = append(queue[:i], queue[i+1:]...)
+		s.queued--
+
+		if len(s.waiting[t.client]) == 0 {
+			delete(s.waiting, t.client)
+			for j, client := range s.clients {
+				if client == t.client {
+					s.clients = append(s.clients[:j], s.clients[j+1:]...)
+					if j < s.next {
+						s.next--
+					}
+					break
+				}
+			}
+		}
+		s.signalRoom()
+		return
+	}
+}
+
+// signalRoom wakes up everyone waiting in SubmitWait. The caller must hold the lock.
+func (s *Scheduler) signalRoom() {
+	close(s.room)
+	s.room = make(chan struct{})
+}
+
+// Wait blocks until the job may run. If ctx is cancelled first the ticket leaves
+// the queue and Wait returns ctx's error; Done doesn't need to be called then.
+func (t *Ticket) Wait(ctx context.Context) error {
+	select {
+	case <-t.admitted:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-t.admitted:
+		// Admitted while being cancelled, give the slot straight back
+		t.once.Do(s.finish)
+	default:
+		s.remove(t)
+	}
+	return ctx.Err()
+}
+
+// Position returns how many jobs will be admitted before this one, plus one.
+// It returns 0 once the job has been admitted.
+func (t *Ticket) Position() int {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-t.admitted:
+		return 0
+	default:
+	}
+
+	// The ticket is admitted in the round matching its place in its client's queue.
+	// Every client gets one turn per round, starting from s.next.
+	round := -1
+	for i, queued := range s.waiting[t.client] {
+		if queued == t {
+			round = i
+			break
+		}
+	}
+	if round < 0 {
+		return 0
+	}
+
+	ahead := round // The client's own earlier tickets
+	own := false
+	for i := range s.clients {
+		client := s.clients[(s.next+i)%len(s.clients)]
+		if client == t.client {
+			own = true
+			continue
+		}
+		// Clients taking their turn before this one also get it in the ticket's own round
+		turns := round
+		if !own {
+			turns++
+		}
+		ahead += min(len(s.waiting[client]), turns)
+	}
+	return ahead + 1
+}
+
+// Done releases the job's slot and admits the next one, or leaves the queue if the
+// job was never admitted. It is safe to call more than once.
+func (t *Ticket) Done() {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-t.admitted:
+	default:
+		s.remove(t)
+		return
+	}
+
+	t.once.Do(func() {
+		run := time.Since(t.start)
+		if s.avgRun == 0 {
+			s.avgRun = run
+		} else {
+			s.avgRun = (s.avgRun*4 + run) / 5
+		}
+		s.finish()
+	})
+}
+
+// finish frees an active slot. The caller must hold the lock.
+func (s *Scheduler) finish() {
+	s.active--
+	s.dispatch()
+}
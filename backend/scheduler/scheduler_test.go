@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPositionTakesTurns(t *testing.T) {
+	s := New(1, 8)
+	running, _ := s.Submit("a")
+	if err := running.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	a1, _ := s.Submit("a")
+	a2, _ := s.Submit("a")
+	b1, _ := s.Submit("b")
+
+	// Clients take turns, so b's first job goes ahead of a's second
+	for ticket, want := range map[*Ticket]int{running: 0, a1: 1, b1: 2, a2: 3} {
+		if got := ticket.Position(); got != want {
+			t.Errorf("Position of %s's ticket = %d, want %d", ticket.client, got, want)
+		}
+	}
+
+	running.Done()
+	if err := a1.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := b1.Position(); got != 1 {
+		t.Errorf("Position after a1 was admitted = %d, want 1", got)
+	}
+
+	// A ticket that leaves the queue makes room for the ones behind it
+	b1.Done()
+	if got := a2.Position(); got != 1 {
+		t.Errorf("Position after b1 left = %d, want 1", got)
+	}
+}
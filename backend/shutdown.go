@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/apikeys"
+)
+
+// Time given to cancelled downloads to kill ffmpeg and remove their partial files.
+const cancelGracePeriod = 10 * time.Second
+
+// shutdown stops the server without losing work. It stops accepting connections and
+// waits up to the configured timeout for requests and background jobs, which stop
+// after their current item. Whatever is still running then is cancelled, killing
+// ffmpeg and removing partial files, and unfinished jobs are saved to be resumed.
+func shutdown(srv *http.Server, cancelRequests context.CancelFunc) {
+	slog.Info("Shutting down, waiting for running downloads", "timeout", cfg.Server.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	jobs.Stop()
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = jobs.Wait(ctx)
+	}
+
+	if err != nil {
+		slog.Warn("Shutdown timeout reached, cancelling running downloads", "error", err)
+		cancelRequests()
+		jobs.Interrupt()
+
+		grace, cancel := context.WithTimeout(context.Background(), cancelGracePeriod)
+		defer cancel()
+		if err := srv.Shutdown(grace); err != nil {
+			slog.Error("Requests still running after cancellation", "error", err)
+		}
+		if err := jobs.Wait(grace); err != nil {
+			slog.Error("Jobs still running after cancellation", "error", err)
+		}
+	}
+
+	if err := saveJobs(cfg.Storage.JobsFile); err != nil {
+		slog.Error("Error saving unfinished jobs", "error", err)
+	}
+	if apiKeys != nil {
+		if err := apiKeys.Flush(); err != nil {
+			slog.Error("Error saving API key usage", "error", err)
+		}
+	}
+	slog.Info("Server stopped")
+}
+
+// saveJobs writes the unfinished background jobs to path, or removes the file when there are none.
+func saveJobs(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	saved := jobs.Unfinished()
+	if len(saved) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"jobs": saved}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode jobs: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	slog.Info("Saved unfinished jobs", "count", len(saved), "path", path)
+	return nil
+}
+
+// resumeJobs restarts the jobs a previous run saved to path and removes the file.
+func resumeJobs(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var file struct {
+		Jobs []savedJob `json:"jobs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	for _, saved := range file.Jobs {
+		if saved.Download != nil {
+			job, ctx := jobs.Restore(saved.Job, saved.owner())
+			key, ok := savedKey(ctx, job.ID, saved)
+			if !ok {
+				continue
+			}
+			jobs.SetResume(job.ID, savedJob{Client: saved.Client, KeyID: saved.KeyID, Download: saved.Download})
+			jobs.Run(func() { runDeferredDownload(ctx, job.ID, saved.Client, nil, key, *saved.Download) })
+			slog.InfoContext(ctx, "Resumed queued download", "type", saved.Download.Type)
+			continue
+		}
+
+		since, err := parseDate(saved.Request.Since)
+		if err != nil {
+			return fmt.Errorf("job %s has an invalid since date: %v", saved.Job.ID, err)
+		}
+		until, err := parseDate(saved.Request.Until)
+		if err != nil {
+			return fmt.Errorf("job %s has an invalid until date: %v", saved.Job.ID, err)
+		}
+
+		// A saved limit above the current max_bulk_posts, or none at all, is held to it
+		if saved.Request.MaxCount == 0 || saved.Request.MaxCount > cfg.Limits.MaxBulkPosts {
+			saved.Request.MaxCount = cfg.Limits.MaxBulkPosts
+		}
+
+		job, ctx := jobs.Restore(saved.Job, saved.owner())
+		key, ok := savedKey(ctx, job.ID, saved)
+		if !ok {
+			continue
+		}
+		jobs.SetResume(job.ID, savedJob{Client: saved.Client, KeyID: saved.KeyID, Request: saved.Request})
+		jobs.Run(func() { runBulkJob(ctx, job.ID, saved.Client, key, saved.Request, since, until) })
+		slog.InfoContext(ctx, "Resumed bulk job", "profile", saved.Request.Profile)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
+// savedKey returns the API key that started a saved job, or nil if it was started
+// without one, so its downloads keep counting against the key. If the key has been
+// deleted since, the restored job is failed instead and ok is false.
+func savedKey(ctx context.Context, jobID string, saved savedJob) (key *apikeys.Key, ok bool) {
+	if saved.KeyID == "" {
+		return nil, true
+	}
+	if apiKeys != nil {
+		key, _ = apiKeys.Get(saved.KeyID)
+	}
+	if key != nil {
+		return key, true
+	}
+
+	slog.WarnContext(ctx, "API key of saved job no longer exists, failing the job", "key_id", saved.KeyID)
+	cancelRemaining(jobID, 0)
+	jobs.Update(jobID, func(job *Job) {
+		job.ErrorCode, job.Error = errInvalidAPIKey.name, "The API key that started the job no longer exists"
+	})
+	jobs.Finish(jobID, JobFailed, nil)
+	return nil, false
+}
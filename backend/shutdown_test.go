@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeJobsFailsJobsOfDeletedKeys(t *testing.T) {
+	testJobs(t)
+
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	saved := savedJob{
+		Job:      Job{ID: "j1", Type: "download", Total: 1, Items: []JobItem{{Profile: "alice.test", PostID: "3kabc", Status: JobQueued}}},
+		Client:   "192.0.2.1",
+		KeyID:    "deleted",
+		Download: &DownloadRequest{Type: "download", Profile: "alice.test", PostID: "3kabc"},
+	}
+	data, _ := json.Marshal(map[string]interface{}{"jobs": []savedJob{saved}})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumeJobs(path); err != nil {
+		t.Fatal(err)
+	}
+	waitJobs(t)
+
+	job, ok := jobs.Get("j1")
+	if !ok || job.Status != JobFailed || job.ErrorCode != errInvalidAPIKey.name {
+		t.Errorf("job = %+v, want it failed with %s", job, errInvalidAPIKey.name)
+	}
+	if job.Items[0].Status != JobCancelled {
+		t.Errorf("item status = %q, want cancelled", job.Items[0].Status)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("jobs file still exists: %v", err)
+	}
+}
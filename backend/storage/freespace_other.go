@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+import "errors"
+
+// FreeSpace is not implemented on this platform and always returns errors.ErrUnsupported.
+func (s *Store) FreeSpace() (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
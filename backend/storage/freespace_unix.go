@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged users on the filesystem holding Root.
+func (s *Store) FreeSpace() (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(s.Root, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,201 @@
+// Package storage lays out downloaded files on disk and cleans them up once they expire.
+package storage
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+)
+
+// Store keeps every post's files in its own directory under Root.
+type Store struct {
+	Root string
+}
+
+// New returns a store rooted at the given directory.
+func New(root string) *Store {
+	return &Store{Root: root}
+}
+
+// PostDir ensures the directory for a post exists and returns its path.
+func (s *Store) PostDir(postID string) (string, error) {
+	return s.ensureDir(postID)
+}
+
+// ThreadDir ensures the directory for a thread bundle exists and returns its path.
+func (s *Store) ThreadDir(postID string) (string, error) {
+	return s.ensureDir(postID + "_thread")
+}
+
+func (s *Store) ensureDir(name string) (string, error) {
+	dir := filepath.Join(s.Root, name)
+	slog.Debug("Ensuring directory", "dir", dir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// RelPath returns the path of a stored file relative to Root, with forward slashes.
+func (s *Store) RelPath(path string) string {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Cleanup removes every directory under Root that hasn't been modified within maxAge.
+func (s *Store) Cleanup(maxAge time.Duration) error {
+	files, err := os.ReadDir(s.Root)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", s.Root, err)
+	}
+
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		dirPath := filepath.Join(s.Root, file.Name())
+		info, err := os.Stat(dirPath)
+		if err != nil {
+			slog.Error("Error getting file info", "error", err)
+			continue
+		}
+
+		if time.Since(info.ModTime()) > maxAge {
+			slog.Info("Deleting expired folder", "dir", dirPath)
+			if err := os.RemoveAll(dirPath); err != nil {
+				slog.Error("Error deleting expired folder", "dir", dirPath, "error", err)
+				continue
+			}
+			metrics.CleanupEvictions.Inc()
+		}
+	}
+	return nil
+}
+
+// Usage returns the total size of the files under Root in bytes.
+func (s *Store) Usage() int64 {
+	var total int64
+	filepath.WalkDir(s.Root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil // Files can vanish while a download is cleaned up
+		}
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// CheckWritable verifies that files can be created under Root.
+func (s *Store) CheckWritable() error {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", s.Root, err)
+	}
+	file, err := os.CreateTemp(s.Root, ".writable-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", s.Root, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// SidecarPath returns the path of the info.json file belonging to a processed file.
+func SidecarPath(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".info.json"
+}
+
+// WriteSidecar writes v as indented JSON next to a processed file.
+func WriteSidecar(filePath string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sidecar: %v", err)
+	}
+
+	path := SidecarPath(filePath)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sidecar: %v", err)
+	}
+
+	slog.Debug("Sidecar written", "path", path)
+	return nil
+}
+
+// ZipFiles writes the given files into a zip archive under the matching entry names.
+func ZipFiles(files, names []string, outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create zip file: %v", err)
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	for i, file := range files {
+		entry, err := archive.Create(names[i])
+		if err != nil {
+			return fmt.Errorf("failed to add %s to zip: %v", file, err)
+		}
+
+		in, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", file, err)
+		}
+		_, err = io.Copy(entry, in)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s to zip: %v", file, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip file: %v", err)
+	}
+
+	slog.Info("Zip created", "path", outputPath)
+	return nil
+}
+
+// MoveFile renames a file, copying it when the destination is on another filesystem.
+// The destination directory is created if needed.
+func MoveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
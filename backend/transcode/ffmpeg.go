@@ -0,0 +1,376 @@
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Rudra644/bluesky_downloader/metrics"
+	"github.com/Rudra644/bluesky_downloader/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FFmpeg implements Transcoder with the ffmpeg and ffprobe binaries.
+type FFmpeg struct {
+	FFmpegPath  string
+	FFprobePath string
+}
+
+// NewFFmpeg returns a transcoder that runs ffmpeg and ffprobe from the PATH.
+func NewFFmpeg() *FFmpeg {
+	return &FFmpeg{FFmpegPath: "ffmpeg", FFprobePath: "ffprobe"}
+}
+
+// run executes ffmpeg, logging its output if it fails. ffmpeg is killed when ctx is
+// cancelled, and outputPath is removed if the run doesn't complete.
+func (f *FFmpeg) run(ctx context.Context, args []string, outputPath string) error {
+	cmd := exec.CommandContext(ctx, f.FFmpegPath, args...)
+
+	// Capture FFmpeg's output for debugging
+	var stdOut, stdErr strings.Builder
+	cmd.Stdout = &stdOut
+	cmd.Stderr = &stdErr
+
+	slog.DebugContext(ctx, "Running FFmpeg", "command", strings.Join(cmd.Args, " "))
+	metrics.ActiveFFmpeg.Inc()
+	defer metrics.ActiveFFmpeg.Dec()
+	if err := cmd.Run(); err != nil {
+		slog.ErrorContext(ctx, "FFmpeg failed", "error", err, "stdout", stdOut.String(), "stderr", stdErr.String())
+		os.Remove(outputPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// Encoders every output format needs.
+var requiredEncoders = []string{"libx264", "aac"}
+
+// Check verifies that ffmpeg and ffprobe can be run and that ffmpeg was built with the
+// encoders the output formats need.
+func (f *FFmpeg) Check(ctx context.Context) error {
+	if err := exec.CommandContext(ctx, f.FFprobePath, "-version").Run(); err != nil {
+		return fmt.Errorf("ffprobe is not available: %v", err)
+	}
+
+	output, err := exec.CommandContext(ctx, f.FFmpegPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+
+	// Each encoder is listed as "<flags> <name> <description>"
+	available := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			available[fields[1]] = true
+		}
+	}
+	var missing []string
+	for _, encoder := range requiredEncoders {
+		if !available[encoder] {
+			missing = append(missing, encoder)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ffmpeg is missing encoders: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// fileSize returns the size of the file at path, or 0 if it can't be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// CombineSegments writes a concat list next to the output and joins the segments with stream copy.
+func (f *FFmpeg) CombineSegments(ctx context.Context, segments []string, outputPath string) (err error) {
+	ctx, span := tracing.Start(ctx, "ffmpeg.CombineSegments", attribute.Int("segments", len(segments)))
+	defer func() { tracing.End(span, err) }()
+
+	// Path to `segments.txt`
+	listPath := filepath.Join(filepath.Dir(outputPath), "segments.txt")
+
+	// Segment entries are relative to the list file
+	var list strings.Builder
+	for _, segment := range segments {
+		list.WriteString(fmt.Sprintf("file '%s'\n", filepath.Base(segment)))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return fmt.Errorf("failed to create segments.txt file: %v", err)
+	}
+	defer os.Remove(listPath)
+
+	err = f.run(ctx, []string{"-y", "-f", "concat", "-safe", "0", "-i", filepath.ToSlash(listPath), "-c", "copy", filepath.ToSlash(outputPath)}, outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to combine segments: %w", err)
+	}
+	span.SetAttributes(attribute.Int64("bytes", fileSize(outputPath)))
+
+	slog.DebugContext(ctx, "Video combined", "path", outputPath)
+	return nil
+}
+
+// Remux copies the streams of the input into an MP4 container without re-encoding.
+func (f *FFmpeg) Remux(ctx context.Context, inputPath, outputPath string) error {
+	err := f.run(ctx, []string{"-y", "-i", filepath.ToSlash(inputPath), "-c", "copy", "-f", "mp4", filepath.ToSlash(outputPath)}, outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to remux video blob: %w", err)
+	}
+
+	slog.DebugContext(ctx, "Video blob remuxed", "path", outputPath)
+	return nil
+}
+
+// Convert trims the start of the video and re-encodes it with codecs suited to the output container.
+func (f *FFmpeg) Convert(ctx context.Context, inputPath, outputPath string, opts Options) (err error) {
+	ctx, span := tracing.Start(ctx, "ffmpeg.Convert", attribute.String("format", opts.Format), attribute.String("start_time", opts.StartTime))
+	defer func() { tracing.End(span, err) }()
+
+	// Normalize paths for FFmpeg (use forward slashes for compatibility)
+	ffmpegInputPath := filepath.ToSlash(inputPath)
+	ffmpegOutputPath := filepath.ToSlash(outputPath)
+
+	slog.DebugContext(ctx, "Trimming video", "input", ffmpegInputPath, "output", ffmpegOutputPath, "format", opts.Format)
+
+	// Ensure the input file exists
+	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+		return fmt.Errorf("input file does not exist: %s", ffmpegInputPath)
+	}
+
+	// FFmpeg arguments
+	args := []string{"-y", "-i", ffmpegInputPath}
+	for _, subtitle := range opts.Subtitles {
+		args = append(args, "-i", filepath.ToSlash(subtitle.Path))
+	}
+	if opts.StartTime != "" {
+		args = append(args, "-ss", opts.StartTime)
+	}
+
+	// Keep every stream of the video and add each caption as its own track
+	if len(opts.Subtitles) > 0 {
+		args = append(args, "-map", "0:v", "-map", "0:a?")
+		for i, subtitle := range opts.Subtitles {
+			args = append(args, "-map", fmt.Sprintf("%d:s", i+1), fmt.Sprintf("-metadata:s:s:%d", i), "language="+subtitle.Lang)
+		}
+	}
+
+	// Container metadata such as title and artist
+	for _, entry := range opts.Metadata {
+		args = append(args, "-metadata", entry)
+	}
+
+	switch opts.Format {
+	case "ts":
+		// Set compatible codecs for MPEG TS
+		args = append(args, "-c:v", "mpeg2video", "-b:v", "1000k", "-c:a", "aac", "-b:a", "128k", "-strict", "experimental", "-f", "mpegts", ffmpegOutputPath)
+	case "mp4":
+		// Default codecs for MP4
+		args = append(args, "-c:v", "libx264", "-preset", "fast", "-crf", "23", "-c:a", "aac", "-strict", "experimental", "-c:s", "mov_text", "-f", "mp4", ffmpegOutputPath)
+	case "mkv":
+		// Same codecs as MP4, Matroska keeps the captions as WebVTT
+		args = append(args, "-c:v", "libx264", "-preset", "fast", "-crf", "23", "-c:a", "aac", "-c:s", "webvtt", "-f", "matroska", ffmpegOutputPath)
+	default:
+		return fmt.Errorf("unsupported format: %s", opts.Format)
+	}
+
+	if err := f.run(ctx, args, outputPath); err != nil {
+		return fmt.Errorf("failed to trim and re-encode video: %w", err)
+	}
+	span.SetAttributes(attribute.Int64("bytes", fileSize(outputPath)))
+
+	slog.InfoContext(ctx, "Video trimmed and re-encoded", "path", ffmpegOutputPath)
+	return nil
+}
+
+// Join concatenates the videos, scaling and padding every part to the frame size of the first.
+// The concat filter needs every part to have the same streams, so parts without audio get
+// silence of their own length, unless none of them has audio.
+func (f *FFmpeg) Join(ctx context.Context, inputPaths []string, outputPath, format string) error {
+	if len(inputPaths) == 0 {
+		return fmt.Errorf("no videos to join")
+	}
+
+	width, height, err := f.probeDimensions(ctx, inputPaths[0])
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	parts := make([]joinPart, len(inputPaths))
+	for i, file := range inputPaths {
+		if parts[i], err = f.probeJoinPart(ctx, file); err != nil {
+			return err
+		}
+		args = append(args, "-i", filepath.ToSlash(file))
+	}
+
+	graph, audio := joinFilter(width, height, parts)
+	args = append([]string{"-y"}, args...)
+	args = append(args, "-filter_complex", graph, "-map", "[v]")
+	if audio {
+		args = append(args, "-map", "[a]")
+	}
+	if format == "ts" {
+		args = append(args, "-c:v", "mpeg2video", "-b:v", "1000k", "-c:a", "aac", "-b:a", "128k")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "fast", "-crf", "23", "-c:a", "aac")
+	}
+	args = append(args, filepath.ToSlash(outputPath))
+
+	if err := f.run(ctx, args, outputPath); err != nil {
+		return fmt.Errorf("failed to concatenate videos: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Videos concatenated", "path", outputPath)
+	return nil
+}
+
+// joinPart describes a video for Join.
+type joinPart struct {
+	audio    bool    // Has an audio stream
+	duration float64 // Seconds
+}
+
+// probeJoinPart reports whether the file has audio and how long it is.
+func (f *FFmpeg) probeJoinPart(ctx context.Context, path string) (joinPart, error) {
+	cmd := exec.CommandContext(ctx, f.FFprobePath, "-v", "error", "-show_entries", "stream=codec_type:format=duration",
+		"-of", "json", filepath.ToSlash(path))
+	output, err := cmd.Output()
+	if err != nil {
+		return joinPart{}, fmt.Errorf("failed to probe %s: %v", path, err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return joinPart{}, fmt.Errorf("failed to parse probe of %s: %v", path, err)
+	}
+
+	var part joinPart
+	for _, stream := range probe.Streams {
+		part.audio = part.audio || stream.CodecType == "audio"
+	}
+	if _, err := fmt.Sscanf(probe.Format.Duration, "%g", &part.duration); err != nil {
+		return joinPart{}, fmt.Errorf("failed to parse duration of %s: %v", path, err)
+	}
+	return part, nil
+}
+
+// joinFilter builds the filter graph Join concatenates parts with into [v] and, if
+// audio is true, [a].
+func joinFilter(width, height int, parts []joinPart) (graph string, audio bool) {
+	for _, part := range parts {
+		audio = audio || part.audio
+	}
+
+	var filters, inputs strings.Builder
+	for i, part := range parts {
+		fmt.Fprintf(&filters, "[%d:v:0]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1[v%d];",
+			i, width, height, width, height, i)
+		fmt.Fprintf(&inputs, "[v%d]", i)
+		if !audio {
+			continue
+		}
+		if part.audio {
+			fmt.Fprintf(&filters, "[%d:a:0]aformat=sample_rates=48000:channel_layouts=stereo[a%d];", i, i)
+		} else {
+			fmt.Fprintf(&filters, "anullsrc=r=48000:cl=stereo,atrim=duration=%.3f[a%d];", part.duration, i)
+		}
+		fmt.Fprintf(&inputs, "[a%d]", i)
+	}
+
+	a := 0
+	if audio {
+		a = 1
+	}
+	fmt.Fprintf(&filters, "%sconcat=n=%d:v=1:a=%d[v]", inputs.String(), len(parts), a)
+	if audio {
+		filters.WriteString("[a]")
+	}
+	return filters.String(), audio
+}
+
+// probeDimensions returns the frame size of the first video stream in the file.
+func (f *FFmpeg) probeDimensions(ctx context.Context, path string) (int, int, error) {
+	cmd := exec.CommandContext(ctx, f.FFprobePath, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height", "-of", "csv=s=x:p=0", filepath.ToSlash(path))
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to probe %s: %v", path, err)
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(output)), "%dx%d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse dimensions of %s: %v", path, err)
+	}
+	return width, height, nil
+}
+
+// ConvertImage converts the first frame of the input to a still image.
+func (f *FFmpeg) ConvertImage(ctx context.Context, inputPath, outputPath, format string) error {
+	args := []string{"-y", "-i", filepath.ToSlash(inputPath), "-frames:v", "1"}
+	switch format {
+	case "jpeg":
+		args = append(args, "-q:v", "2")
+	case "png":
+	case "webp":
+		args = append(args, "-c:v", "libwebp", "-quality", "90")
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+	args = append(args, filepath.ToSlash(outputPath))
+
+	if err := f.run(ctx, args, outputPath); err != nil {
+		return fmt.Errorf("failed to convert image: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Image converted", "path", outputPath)
+	return nil
+}
+
+// ConvertAnimation converts a short animation to the requested format. There is no audio to keep.
+func (f *FFmpeg) ConvertAnimation(ctx context.Context, inputPath, outputPath, format string, metadata []string) error {
+	args := []string{"-y", "-i", filepath.ToSlash(inputPath)}
+	for _, entry := range metadata {
+		args = append(args, "-metadata", entry)
+	}
+
+	switch format {
+	case "mp4":
+		// H.264 needs even dimensions
+		args = append(args, "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-c:v", "libx264", "-preset", "fast", "-crf", "23",
+			"-pix_fmt", "yuv420p", "-movflags", "+faststart", "-an", "-f", "mp4")
+	case "gif":
+		// Generate a palette from the clip itself for better colors
+		args = append(args, "-vf", "split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse", "-loop", "0", "-f", "gif")
+	case "webp":
+		args = append(args, "-c:v", "libwebp", "-quality", "80", "-loop", "0", "-an", "-f", "webp")
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+	args = append(args, filepath.ToSlash(outputPath))
+
+	if err := f.run(ctx, args, outputPath); err != nil {
+		return fmt.Errorf("failed to convert animation: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Animation converted", "path", outputPath)
+	return nil
+}
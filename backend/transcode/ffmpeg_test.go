@@ -0,0 +1,43 @@
+package transcode
+
+import "testing"
+
+func TestJoinFilter(t *testing.T) {
+	const scale = "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1"
+	tests := []struct {
+		name  string
+		parts []joinPart
+		graph string
+		audio bool
+	}{
+		{
+			"every part has audio",
+			[]joinPart{{audio: true, duration: 10}, {audio: true, duration: 5}},
+			"[0:v:0]" + scale + "[v0];[0:a:0]aformat=sample_rates=48000:channel_layouts=stereo[a0];" +
+				"[1:v:0]" + scale + "[v1];[1:a:0]aformat=sample_rates=48000:channel_layouts=stereo[a1];" +
+				"[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a]",
+			true,
+		},
+		{
+			"a silent part gets silence",
+			[]joinPart{{audio: true, duration: 10}, {duration: 4.5}},
+			"[0:v:0]" + scale + "[v0];[0:a:0]aformat=sample_rates=48000:channel_layouts=stereo[a0];" +
+				"[1:v:0]" + scale + "[v1];anullsrc=r=48000:cl=stereo,atrim=duration=4.500[a1];" +
+				"[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a]",
+			true,
+		},
+		{
+			"no part has audio",
+			[]joinPart{{duration: 10}, {duration: 4.5}},
+			"[0:v:0]" + scale + "[v0];[1:v:0]" + scale + "[v1];[v0][v1]concat=n=2:v=1:a=0[v]",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		graph, audio := joinFilter(1280, 720, tt.parts)
+		if graph != tt.graph || audio != tt.audio {
+			t.Errorf("%s: joinFilter = %q, %v, want %q, %v", tt.name, graph, audio, tt.graph, tt.audio)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package transcode
+
+import (
+	"context"
+
+	"github.com/Rudra644/bluesky_downloader/scheduler"
+)
+
+// limited runs every conversion of a Transcoder under a shared limiter.
+type limited struct {
+	t       Transcoder
+	limiter *scheduler.Limiter
+}
+
+// Limit returns a Transcoder that waits for a slot of limiter before each conversion,
+// so only a fixed number of them run at once however many downloads are in flight.
+func Limit(t Transcoder, limiter *scheduler.Limiter) Transcoder {
+	if limiter == nil {
+		return t
+	}
+	return &limited{t: t, limiter: limiter}
+}
+
+// run waits for a slot and then runs fn.
+func (l *limited) run(ctx context.Context, fn func() error) error {
+	if err := l.limiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer l.limiter.Release()
+	return fn()
+}
+
+// Check passes through to the wrapped transcoder without waiting for a slot.
+func (l *limited) Check(ctx context.Context) error {
+	if checker, ok := l.t.(Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+func (l *limited) CombineSegments(ctx context.Context, segments []string, outputPath string) error {
+	return l.run(ctx, func() error { return l.t.CombineSegments(ctx, segments, outputPath) })
+}
+
+func (l *limited) Remux(ctx context.Context, inputPath, outputPath string) error {
+	return l.run(ctx, func() error { return l.t.Remux(ctx, inputPath, outputPath) })
+}
+
+func (l *limited) Convert(ctx context.Context, inputPath, outputPath string, opts Options) error {
+	return l.run(ctx, func() error { return l.t.Convert(ctx, inputPath, outputPath, opts) })
+}
+
+func (l *limited) Join(ctx context.Context, inputPaths []string, outputPath, format string) error {
+	return l.run(ctx, func() error { return l.t.Join(ctx, inputPaths, outputPath, format) })
+}
+
+func (l *limited) ConvertImage(ctx context.Context, inputPath, outputPath, format string) error {
+	return l.run(ctx, func() error { return l.t.ConvertImage(ctx, inputPath, outputPath, format) })
+}
+
+func (l *limited) ConvertAnimation(ctx context.Context, inputPath, outputPath, format string, metadata []string) error {
+	return l.run(ctx, func() error { return l.t.ConvertAnimation(ctx, inputPath, outputPath, format, metadata) })
+}
@@ -0,0 +1,55 @@
+// Package transcode turns downloaded media into the output formats the downloader offers.
+package transcode
+
+import "context"
+
+// Output containers Convert can produce.
+var VideoFormats = map[string]bool{"mp4": true, "ts": true, "mkv": true}
+
+// Image formats ConvertImage can produce, with their file extensions. "original" keeps the source format.
+var ImageFormats = map[string]string{
+	"original": "",
+	"jpeg":     ".jpg",
+	"png":      ".png",
+	"webp":     ".webp",
+}
+
+// Formats ConvertAnimation can produce.
+var AnimationFormats = map[string]bool{"mp4": true, "gif": true, "webp": true}
+
+// Subtitle is a caption file on disk to mux into a video as a soft subtitle track.
+type Subtitle struct {
+	Lang string
+	Path string
+}
+
+// Options control how Convert encodes a video.
+type Options struct {
+	Format    string     // One of VideoFormats
+	StartTime string     // Skip everything before this timestamp
+	Subtitles []Subtitle // Muxed as soft subtitles (mp4 and mkv only)
+	Metadata  []string   // Container metadata as key=value entries
+}
+
+// Checker is implemented by transcoders that can verify the tools they run are installed.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Transcoder runs the media conversions of the download pipeline. Cancelling ctx stops
+// a conversion and removes its partial output.
+type Transcoder interface {
+	// CombineSegments joins HLS segments into a single MP4 without re-encoding.
+	CombineSegments(ctx context.Context, segments []string, outputPath string) error
+	// Remux copies the streams of a video into an MP4 container without re-encoding.
+	Remux(ctx context.Context, inputPath, outputPath string) error
+	// Convert re-encodes a video into the requested format.
+	Convert(ctx context.Context, inputPath, outputPath string, opts Options) error
+	// Join concatenates videos into one, scaling every part to the frame size of the first.
+	// Parts without audio are filled with silence.
+	Join(ctx context.Context, inputPaths []string, outputPath, format string) error
+	// ConvertImage converts a still image to one of ImageFormats.
+	ConvertImage(ctx context.Context, inputPath, outputPath, format string) error
+	// ConvertAnimation converts a short animation without audio to one of AnimationFormats.
+	ConvertAnimation(ctx context.Context, inputPath, outputPath, format string, metadata []string) error
+}
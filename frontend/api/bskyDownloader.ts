@@ -6,13 +6,43 @@ export const fetchMetadata = async (url: string) => {
   return response.data;
 };
 
-// Download video from the backend
-export const downloadVideo = async (data: {
-  profile: string;
-  postID: string;
-  resolution: string;
-  format: string;
-}) => {
+// Download video from the backend. When the server is busy it answers with a job
+// instead, which is polled until the download is done; onQueued gets its queue position.
+export const downloadVideo = async (
+  data: {
+    profile: string;
+    postID: string;
+    resolution: string;
+    format: string;
+  },
+  onQueued?: (position: number) => void
+) => {
   const response = await apiInstance.post("/download", data);
-  return response.data;
+  if (response.status !== 202) {
+    return response.data;
+  }
+  onQueued?.(response.data.queuePosition);
+  return waitForJob(response.data.jobID, onQueued);
+};
+
+// Poll a queued download until it has finished, returning its item like a direct download
+const waitForJob = async (jobID: string, onQueued?: (position: number) => void) => {
+  for (;;) {
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+    const { data: job } = await apiInstance.get(`/jobs/${jobID}`);
+    const item = job.items?.[0];
+
+    if (job.status === "completed") {
+      return item;
+    }
+    if (job.status === "failed" || job.status === "cancelled") {
+      throw new Error(item?.error || job.error || `Download ${job.status}`);
+    }
+    onQueued?.(job.queuePosition || 0);
+  }
+};
+
+// Message to show for a failed request, preferring the one the backend sent
+export const errorMessage = (error: any, fallback: string): string => {
+  return error?.response?.data?.message || error?.message || fallback;
 };
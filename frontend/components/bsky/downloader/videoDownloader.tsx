@@ -9,7 +9,7 @@ import { Card } from "@/components/ui/card";
 import { Input } from "@/components/ui/input";
 import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from "@/components/ui/select";
 import { Heart, MessageSquare, Repeat } from "lucide-react";
-import { fetchMetadata, downloadVideo } from "@/api/bskyDownloader";
+import { fetchMetadata, downloadVideo, errorMessage } from "@/api/bskyDownloader";
 import Image from "next/image";
 import { formatNumber } from "@/utils/formatNumber";
 
@@ -33,6 +33,7 @@ export default function VideoDownloader() {
   const [postURL, setPostURL] = useState<string>("");
   const [loading, setLoading] = useState(false);
   const [downloadLoading, setDownloadLoading] = useState(false);
+  const [queuePosition, setQueuePosition] = useState(0);
   const [error, setError] = useState<string | null>(null);
 
   const handleFetchMetadata = async () => {
@@ -56,7 +57,7 @@ export default function VideoDownloader() {
       )[0];
       setSelectedResolution(highestResolution);
     } catch (error: any | string) {
-      setError(error.message || "An error occurred");
+      setError(errorMessage(error, "An error occurred"));
     } finally {
       setLoading(false);
     }
@@ -72,12 +73,15 @@ export default function VideoDownloader() {
     setError(null);
 
     try {
-      const response = await downloadVideo({
-        profile: metadata.profile,
-        postID: metadata.postID,
-        resolution: selectedResolution,
-        format: selectedFormat,
-      });
+      const response = await downloadVideo(
+        {
+          profile: metadata.profile,
+          postID: metadata.postID,
+          resolution: selectedResolution,
+          format: selectedFormat,
+        },
+        setQueuePosition
+      );
 
       const { filename } = response; // Ensure backend returns correct filename
       const link = document.createElement("a");
@@ -87,9 +91,10 @@ export default function VideoDownloader() {
       link.click();
       document.body.removeChild(link);
     } catch (error: any) {
-      setError(error.message || "An error occurred during processing");
+      setError(errorMessage(error, "An error occurred during processing"));
     } finally {
       setDownloadLoading(false);
+      setQueuePosition(0);
     }
   };
 
@@ -211,7 +216,13 @@ export default function VideoDownloader() {
                     downloadLoading && "cursor-not-allowed opacity-70"
                   }`}
                 >
-                  {downloadLoading ? <SyncLoader color="#fff" size={6} /> : "Download"}
+                  {downloadLoading && queuePosition > 0 ? (
+                    `Queued, position ${queuePosition}`
+                  ) : downloadLoading ? (
+                    <SyncLoader color="#fff" size={6} />
+                  ) : (
+                    "Download"
+                  )}
                 </Button>
               )}
             </div>